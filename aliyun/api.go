@@ -1,32 +1,24 @@
 package aliyun
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
-	"fmt"
-	"go-aliyun-webdav/aliyun/cache"
 	"go-aliyun-webdav/aliyun/model"
 	"go-aliyun-webdav/aliyun/net"
-	"io/ioutil"
+	"io"
 	"net/http"
-	"os"
 	"strconv"
-
-	"github.com/tidwall/gjson"
 )
 
-func GetList(token string, driveId string, parentFileId string, marker ...string) (model.FileListModel, error) {
-
+// GetList returns every item in the folder parentFileId, following the
+// pagination markers of the list API.
+func (c *Client) GetList(parentFileId string) ([]model.ListModel, error) {
 	if len(parentFileId) == 0 {
 		parentFileId = "root"
 	}
-
-	var list model.FileListModel
-	if result, ok := cache.GoCache.Get(parentFileId); ok {
-		list, ok = result.(model.FileListModel)
-		if ok {
-			return list, nil
-		}
+	_, driveId, err := c.credentials()
+	if err != nil {
+		return nil, err
 	}
 
 	postData := make(map[string]interface{})
@@ -41,154 +33,100 @@ func GetList(token string, driveId string, parentFileId string, marker ...string
 	postData["fields"] = "*"
 	postData["order_by"] = "updated_at"
 	postData["order_direction"] = "DESC"
-	//add marker post data
-	if len(marker) > 0 {
-		postData["marker"] = marker[0]
-	}
-
-	data, err := json.Marshal(postData)
-	if err != nil {
-		fmt.Println("获取列表转义数据失败", err)
-		return model.FileListModel{}, err
-	}
-
-	body := net.Post(model.APILISTURL, token, data)
 
-	e := json.Unmarshal(body, &list)
-	if e != nil {
-		fmt.Println(e)
-	}
-	if list.NextMarker != "" {
-		var newList, _ = GetList(token, driveId, parentFileId, list.NextMarker)
-		list.Items = append(list.Items, newList.Items...)
-		list.NextMarker = newList.NextMarker
-		fmt.Println("next marker: " + list.NextMarker)
-	}
-	if len(list.Items) > 0 {
-		cache.GoCache.SetDefault(parentFileId, list)
-	}
-	return list, nil
-}
-
-func GetFilePath(token string, driveId string, parentFileId string, fileId string, typeStr string) (string, error) {
-
-	if len(parentFileId) == 0 {
-		parentFileId = "root"
-	}
-	path := "/"
-	var list model.ListFilePath
-	if result, ok := cache.GoCache.Get(parentFileId + "path"); ok {
-		path, ok = result.(string)
-		if ok {
-			return path, nil
+	var items []model.ListModel
+	for {
+		var list model.FileListModel
+		if err := c.post(model.APILISTURL, postData, &list); err != nil {
+			return nil, err
 		}
-	}
-
-	postData := make(map[string]interface{})
-	postData["drive_id"] = driveId
-	postData["file_id"] = fileId
-
-	data, err := json.Marshal(postData)
-	if err != nil {
-		fmt.Println("获取列表转义数据失败", err)
-		return "/", err
-	}
-
-	body := net.Post(model.APIFILEPATH, token, data)
-
-	e := json.Unmarshal(body, &list)
-	if e != nil {
-		fmt.Println(e)
-	}
-	minNum := 0
-	if typeStr == "folder" {
-		minNum = 1
-	}
-	for i := len(list.Items); i > minNum; i-- {
-		if list.Items[i-1].Type == "folder" {
-			path += list.Items[i-1].Name + "/"
+		items = append(items, list.Items...)
+		if list.NextMarker == "" {
+			return items, nil
 		}
+		postData["marker"] = list.NextMarker
 	}
-
-	cache.GoCache.SetDefault(parentFileId+"path", path)
-
-	return path, nil
 }
 
-func GetFile(w http.ResponseWriter, url string, token string, rangeStr string, ifRange string) bool {
-
-	body := net.Get(w, url, token, rangeStr, ifRange)
-	//net.GetProxy(w, req, url, token)
-	return body
-	//return []byte{}
+// GetFile requests a signed download URL, passing rangeStr and ifRange on
+// as the Range and If-Range headers. The request is given up once ctx is
+// done. The caller must close the response body.
+func (c *Client) GetFile(ctx context.Context, url string, rangeStr string, ifRange string) (*http.Response, error) {
+	token, _, err := c.credentials()
+	if err != nil {
+		return nil, err
+	}
+	return net.Get(ctx, c.HTTPClient, url, token, rangeStr, ifRange)
 }
 
-func RefreshToken(refreshToken string) model.RefreshTokenModel {
-	path := refreshToken
-	if _, errs := os.Stat(path); errs == nil {
-		buf, _ := ioutil.ReadFile(path)
-		refreshToken = string(buf)
-		if len(refreshToken) >= 32 {
-			refreshToken = refreshToken[:32] // refreshToken is only 32 bit?? FIXME
-		}
-	}
-	rs := net.Post(model.APIREFRESHTOKENURL, "", []byte(`{"refresh_token":"`+refreshToken+`"}`))
+// RefreshToken exchanges refreshToken for a new access token. The response
+// carries a new refresh token as well; refreshToken must not be used again.
+func RefreshToken(refreshToken string) (model.RefreshTokenModel, error) {
 	var refresh model.RefreshTokenModel
-
-	if len(rs) <= 0 {
-		fmt.Println("刷新token失败")
-		return refresh
-	}
-
-	err := json.Unmarshal(rs, &refresh)
+	c := &Client{}
+	err := c.post(model.APIREFRESHTOKENURL, map[string]string{"refresh_token": refreshToken}, &refresh)
 	if err != nil {
-		fmt.Println("刷新token失败,失败信息", err)
-		fmt.Println("刷新token返回信息", refresh)
-		return refresh
-	}
-
-	if refreshToken == refresh.RefreshToken {
-		return refresh
+		return model.RefreshTokenModel{}, err
 	}
+	return refresh, nil
+}
 
-	_, err = os.Stat(path)
-	if errors.Is(err, os.ErrNotExist) {
-		return refresh
-	}
+// RemoveTrash moves the item fileId to the recycle bin.
+func (c *Client) RemoveTrash(fileId string) error {
+	_, driveId, err := c.credentials()
 	if err != nil {
-		fmt.Println("更新token文件失败,失败信息", err)
-		return refresh
+		return err
 	}
+	return c.post(model.APIREMOVETRASH, map[string]string{
+		"drive_id": driveId,
+		"file_id":  fileId,
+	}, nil)
+}
 
-	err = ioutil.WriteFile(path, []byte(refresh.RefreshToken), 0600)
+// DeleteFile deletes the item fileId for good, bypassing the recycle bin.
+func (c *Client) DeleteFile(fileId string) error {
+	_, driveId, err := c.credentials()
 	if err != nil {
-		fmt.Println("更新token文件失败,失败信息", err)
+		return err
 	}
-
-	return refresh
+	return c.post(model.APIFILEDELETE, map[string]string{
+		"drive_id": driveId,
+		"file_id":  fileId,
+	}, nil)
 }
 
-func RemoveTrash(token string, driveId string, fileId string, parentFileId string) bool {
-	rs := net.Post(model.APIREMOVETRASH, token, []byte(`{"drive_id":"`+driveId+`","file_id":"`+fileId+`"}`))
-	if len(rs) == 0 {
-		cache.GoCache.Delete(parentFileId)
+// Remove moves the item fileId to the recycle bin, or deletes it for good if
+// PermanentDelete is set.
+func (c *Client) Remove(fileId string) error {
+	if c.PermanentDelete {
+		return c.DeleteFile(fileId)
 	}
-	return false
+	return c.RemoveTrash(fileId)
 }
 
-func ReName(token string, driveId string, newName string, fileId string) bool {
-	rs := net.Post(model.APIFILEUPDATE, token, []byte(`{"drive_id":"`+driveId+`","file_id":"`+fileId+`","name":"`+newName+`","check_name_mode":"refuse"}`))
-	var m model.ListModel
-	e := json.Unmarshal(rs, &m)
-	if e != nil {
-		fmt.Println(e)
+// ReName renames the item fileId to newName within its folder.
+func (c *Client) ReName(newName string, fileId string) (model.ListModel, error) {
+	_, driveId, err := c.credentials()
+	if err != nil {
+		return model.ListModel{}, err
 	}
-	cache.GoCache.Delete(m.ParentFileId)
-	fmt.Println(string(rs))
-	return true
+	var m model.ListModel
+	err = c.post(model.APIFILEUPDATE, map[string]string{
+		"drive_id":        driveId,
+		"file_id":         fileId,
+		"name":            newName,
+		"check_name_mode": "refuse",
+	}, &m)
+	return m, err
 }
-func MakeDir(token string, driveId string, name string, parentFileId string) bool {
-	rs := net.Post(model.APIMKDIR, token, []byte(`{"drive_id":"`+driveId+`","parent_file_id":"`+parentFileId+`","name":"`+name+`","check_name_mode":"refuse","type":"folder"}`))
+
+// MakeDir creates the folder name in the folder parentFileId. It fails with
+// an error satisfying errors.Is(err, os.ErrExist) if the name is taken.
+func (c *Client) MakeDir(name string, parentFileId string) (model.ListModel, error) {
+	_, driveId, err := c.credentials()
+	if err != nil {
+		return model.ListModel{}, err
+	}
 	//正确返回示例
 	//{
 	//	"parent_file_id": "root",
@@ -199,99 +137,212 @@ func MakeDir(token string, driveId string, name string, parentFileId string) boo
 	//	"file_name": "新0000",
 	//	"encrypt_mode": "none"
 	//}
-	if gjson.GetBytes(rs, "file_name").Str == name {
-		cache.GoCache.Delete(parentFileId)
+	var rs struct {
+		model.ListModel
+		FileName string `json:"file_name"`
+		Exist    bool   `json:"exist"`
 	}
-	return true
+	err = c.post(model.APIMKDIR, map[string]string{
+		"drive_id":        driveId,
+		"parent_file_id":  parentFileId,
+		"name":            name,
+		"check_name_mode": "refuse",
+		"type":            "folder",
+	}, &rs)
+	if err != nil {
+		return model.ListModel{}, err
+	}
+	if rs.Exist {
+		// The drive answers with the folder already there instead of
+		// refusing.
+		return model.ListModel{}, &APIError{StatusCode: http.StatusConflict, Code: "AlreadyExist.Folder", Message: name + " already exists"}
+	}
+	rs.ListModel.Name = rs.FileName
+	return rs.ListModel, nil
 }
 
-func GetFileDetail(token string, driveId string, fileId string) model.ListModel {
-	rs := net.Post(model.APIFILEDETAIL, token, []byte(`{"drive_id":"`+driveId+`","file_id":"`+fileId+`"}`))
-	var m model.ListModel
-	e := json.Unmarshal(rs, &m)
-	if e != nil {
-		fmt.Println(e)
+// GetFileDetail returns the item fileId.
+func (c *Client) GetFileDetail(fileId string) (model.ListModel, error) {
+	_, driveId, err := c.credentials()
+	if err != nil {
+		return model.ListModel{}, err
 	}
-	return m
+	var m model.ListModel
+	err = c.post(model.APIFILEDETAIL, map[string]string{
+		"drive_id": driveId,
+		"file_id":  fileId,
+	}, &m)
+	return m, err
 }
 
-func BatchFile(token string, driveId string, fileId string, parentFileId string) bool {
-
-	//	{
-	//		"requests": ,
-	//	"resource": "file"
-	//	}
+// maxBatch is the most requests the batch endpoint takes at once.
+const maxBatch = 100
 
-	var bodyJson string = `{"drive_id": "` + driveId + `","file_id": "` + fileId + `","to_drive_id": "` + driveId + `","to_parent_file_id": "` + parentFileId + `"}`
-	var contentType string = `{"Content-Type": "application/json"}`
-
-	var requests string = `{"requests":[{"body": ` + bodyJson + `,"headers": ` + contentType + `,"id": "` + fileId + `","method": "POST","url": "/file/move"}],"resource": "file"}`
-
-	rs := net.Post(model.APIFILEBATCH, token, []byte(requests))
-	if gjson.GetBytes(rs, "responses.0.friends").Num == 200 {
-		cache.GoCache.Delete(parentFileId)
-		cache.GoCache.Delete(fileId)
-		return true
+// batch sends a request to the file endpoint url, e.g. "/file/copy", for
+// each of bodies through the batch endpoint, and returns the error of each
+// request, or nil where it succeeded. The drive ID is added to every body.
+func (c *Client) batch(url string, bodies []map[string]string) ([]error, error) {
+	_, driveId, err := c.credentials()
+	if err != nil {
+		return nil, err
 	}
-
-	return false
+	errs := make([]error, len(bodies))
+	answered := make([]bool, len(bodies))
+	for start := 0; start < len(bodies); start += maxBatch {
+		end := start + maxBatch
+		if end > len(bodies) {
+			end = len(bodies)
+		}
+		requests := make([]map[string]interface{}, 0, end-start)
+		for i := start; i < end; i++ {
+			body := map[string]string{"drive_id": driveId}
+			for k, v := range bodies[i] {
+				body[k] = v
+			}
+			requests = append(requests, map[string]interface{}{
+				"body":    body,
+				"headers": map[string]string{"Content-Type": "application/json"},
+				"id":      strconv.Itoa(i),
+				"method":  "POST",
+				"url":     url,
+			})
+		}
+		var rs struct {
+			Responses []struct {
+				Id     string          `json:"id"`
+				Status int             `json:"status"`
+				Body   json.RawMessage `json:"body"`
+			} `json:"responses"`
+		}
+		if err := c.post(model.APIFILEBATCH, map[string]interface{}{
+			"requests": requests,
+			"resource": "file",
+		}, &rs); err != nil {
+			return nil, err
+		}
+		for _, r := range rs.Responses {
+			i, err := strconv.Atoi(r.Id)
+			if err != nil || i < start || i >= end {
+				continue
+			}
+			answered[i] = true
+			if r.Status < 200 || r.Status > 299 {
+				errs[i] = newAPIError(r.Status, r.Body)
+			}
+		}
+		for i := start; i < end; i++ {
+			if !answered[i] {
+				errs[i] = &APIError{StatusCode: http.StatusOK, Code: "EmptyBatchResponse", Message: "no response for " + bodies[i]["file_id"]}
+			}
+		}
+	}
+	return errs, nil
 }
-func UpdateFileFolder(token string, driveId string, fileName string, parentFileId string) bool {
 
-	//	{
-	//		"requests": ,
-	//	"resource": "file"
-	//	}
-	createData := `{"drive_id": "` + driveId + `","parent_file_id": "` + parentFileId + `","name": "` + fileName + `","check_name_mode": "refuse","type": "folder"}`
-	net.Post(model.APIFILEUPLOAD, token, []byte(createData))
-	// rs := net.Post(model.APIFILEUPLOAD, token, []byte(createData))
-	// fmt.Println(string(rs))
-	//正确返回占星显示
-	//	{"parent_file_id":"60794ad941ee2d8d24f843b7a0ffd80279927dfc","type":"folder","file_id":"613caeb4d5b1ba9fb4604d4aa5aef2b408ab3121","domain_id":"bj29","drive_id":"1662258","file_name":"1SDSDSD.png","encrypt_mode":"none"}
-	//
-	//	{
-	//		"parent_file_id": "root",
-	//		"part_info_list": [
-	//	{
-	//		"part_number": 1,
-	//		"upload_url": "https://bj29.cn-beijing.data.alicloudccp.com/igQPcuUn%2F1662258%2F613a1091919bb599f4ac4917bfe16af6b7066795%2F613a10919ab3804e88e846ee9ea459de51d8f58d?partNumber=1&uploadId=BD8449BB161A4F54A1252E3B5B121641&x-oss-access-key-id=LTAIsE5mAn2F493Q&x-oss-expires=1631198881&x-oss-signature=wp2WCgyfqxZhJH%2BsPaw6XASRKXHa92p3e9NOjcN4Ui8%3D&x-oss-signature-version=OSS2",
-	//		"internal_upload_url": "http://ccp-bj29-bj-1592982087.oss-cn-beijing-internal.aliyuncs.com/igQPcuUn%2F1662258%2F613a1091919bb599f4ac4917bfe16af6b7066795%2F613a10919ab3804e88e846ee9ea459de51d8f58d?partNumber=1&uploadId=BD8449BB161A4F54A1252E3B5B121641&x-oss-access-key-id=LTAIsE5mAn2F493Q&x-oss-expires=1631198881&x-oss-signature=wp2WCgyfqxZhJH%2BsPaw6XASRKXHa92p3e9NOjcN4Ui8%3D&x-oss-signature-version=OSS2",
-	//		"content_type": ""
-	//	}
-	//],
-	//	"upload_id": "BD8449BB161A4F54A1252E3B5B121641",
-	//	"rapid_upload": false,
-	//	"type": "file",
-	//	"file_id": "613a1091919bb599f4ac4917bfe16af6b7066795",
-	//	"domain_id": "bj29",
-	//	"drive_id": "1662258",
-	//	"file_name": "photo_1614943806132229.jpg",
-	//	"encrypt_mode": "none",
-	//	"location": "cn-beijing"
-	//	}
+// MoveFile moves the item fileId into the folder parentFileId under the name
+// newName, in one step. An empty newName keeps the item's name.
+func (c *Client) MoveFile(fileId string, parentFileId string, newName string) error {
+	_, driveId, err := c.credentials()
+	if err != nil {
+		return err
+	}
+	body := map[string]string{
+		"file_id":           fileId,
+		"to_drive_id":       driveId,
+		"to_parent_file_id": parentFileId,
+	}
+	if newName != "" {
+		body["new_name"] = newName
+	}
+	errs, err := c.batch("/file/move", []map[string]string{body})
+	if err != nil {
+		return err
+	}
+	return errs[0]
+}
 
-	return false
+// CopyFile copies the item fileId into the folder parentFileId under the
+// name newName. Folders are copied with all their content.
+func (c *Client) CopyFile(fileId string, parentFileId string, newName string) error {
+	_, driveId, err := c.credentials()
+	if err != nil {
+		return err
+	}
+	errs, err := c.batch("/file/copy", []map[string]string{{
+		"file_id":           fileId,
+		"to_drive_id":       driveId,
+		"to_parent_file_id": parentFileId,
+		"new_name":          newName,
+	}})
+	if err != nil {
+		return err
+	}
+	return errs[0]
 }
 
-func UpdateFileFile(token string, driveId string, fileName string, parentFileId string, size string, length int) ([]gjson.Result, string, string) {
+// CopyFiles copies the items fileIds into the folder parentFileId, keeping
+// their names, and returns the error of each copy, or nil where it
+// succeeded. Folders are copied with all their content.
+func (c *Client) CopyFiles(fileIds []string, parentFileId string) ([]error, error) {
+	_, driveId, err := c.credentials()
+	if err != nil {
+		return nil, err
+	}
+	bodies := make([]map[string]string, 0, len(fileIds))
+	for _, id := range fileIds {
+		bodies = append(bodies, map[string]string{
+			"file_id":           id,
+			"to_drive_id":       driveId,
+			"to_parent_file_id": parentFileId,
+		})
+	}
+	return c.batch("/file/copy", bodies)
+}
 
+// UpdateFileFile creates the file fileName of size bytes in the folder
+// parentFileId, replacing any file of that name, and returns the upload URLs
+// of its length parts. If
+// contentHash, the upper-case hex SHA1 of the content, and its proofCode
+// are given, the drive tries a rapid upload first: when it already holds
+// the content, the file is created at once, RapidUpload is set and no
+// parts need to be uploaded.
+func (c *Client) UpdateFileFile(fileName string, parentFileId string, size int64, length int, contentHash string, proofCode string) (model.UploadModel, error) {
 	if len(parentFileId) == 0 {
 		parentFileId = "root"
 	}
+	_, driveId, err := c.credentials()
+	if err != nil {
+		return model.UploadModel{}, err
+	}
 
-	var partStr string = "["
+	partInfoList := make([]map[string]int, 0, length)
 	for i := 0; i < length; i++ {
-		partStr += `{"part_number":` + strconv.Itoa(i+1) + `},`
+		partInfoList = append(partInfoList, map[string]int{"part_number": i + 1})
+	}
+	createData := map[string]interface{}{
+		"drive_id":          driveId,
+		"part_info_list":    partInfoList,
+		"parent_file_id":    parentFileId,
+		"name":              fileName,
+		"type":              "file",
+		"check_name_mode":   "overwrite",
+		"size":              size,
+		"content_hash_name": "none",
+		"proof_version":     "v1",
+	}
+	if contentHash != "" {
+		createData["content_hash_name"] = "sha1"
+		createData["content_hash"] = contentHash
+		createData["proof_code"] = proofCode
+	}
+	var rs model.UploadModel
+	if err := c.post(model.APIFILEUPLOADFILE, createData, &rs); err != nil {
+		return model.UploadModel{}, err
 	}
-	partStr = partStr[:len(partStr)-1]
-	partStr += "]"
-	createData := `{"drive_id":"` + driveId + `","part_info_list":` + partStr + `,"parent_file_id":"` + parentFileId + `","name":"` + fileName + `","type":"file","check_name_mode":"auto_rename","size":` + size + `,"content_hash_name":"none","proof_version":"v1"}`
-	rs := net.Post(model.APIFILEUPLOADFILE, token, []byte(createData))
-	urlArr := gjson.GetBytes(rs, "part_info_list.#.upload_url").Array()
-	if len(urlArr) == 0 {
-		fmt.Println("创建文件出错", string(rs))
+	if !rs.RapidUpload && length > 0 && len(rs.PartInfoList) != length {
+		return rs, &APIError{StatusCode: http.StatusOK, Code: "InvalidPartInfoList", Message: "got " + strconv.Itoa(len(rs.PartInfoList)) + " upload urls for " + strconv.Itoa(length) + " parts"}
 	}
-	return urlArr, gjson.GetBytes(rs, "upload_id").Str, gjson.GetBytes(rs, "file_id").Str
+	return rs, nil
 	//正确返回占星显示
 	//
 	//	{
@@ -314,49 +365,86 @@ func UpdateFileFile(token string, driveId string, fileName string, parentFileId
 	//	"encrypt_mode": "none",
 	//	"location": "cn-beijing"
 	//	}
-
-	//return false
-}
-func UploadFile(url string, token string, data []byte) {
-	net.Put(url, token, data)
 }
-func UploadFileComplete(token string, driveId string, uploadId string, fileId string, parentId string) bool {
-	//	private String drive_id;
-	//	private String file_id;
-	//	private String upload_id;
-	//	{
-	//		"requests": ,
-	//	"resource": "file"
-	//	}
-	createData := `{"drive_id": "` + driveId + `","file_id": "` + fileId + `","upload_id":"` + uploadId + `"}`
 
-	rs := net.Post(model.APIFILECOMPLETE, token, []byte(createData))
-	fmt.Println(string(rs))
-	//正确返回占星显示
-	//	}
-	cache.GoCache.Delete(parentId)
-
-	return false
+// GetUploadUrl returns fresh upload URLs for the parts partNumbers of the
+// upload uploadId of the file fileId, for when the earlier ones expired.
+func (c *Client) GetUploadUrl(fileId string, uploadId string, partNumbers []int) ([]model.PartInfo, error) {
+	_, driveId, err := c.credentials()
+	if err != nil {
+		return nil, err
+	}
+	partInfoList := make([]map[string]int, 0, len(partNumbers))
+	for _, n := range partNumbers {
+		partInfoList = append(partInfoList, map[string]int{"part_number": n})
+	}
+	var rs model.UploadModel
+	err = c.post(model.APIFILEUPLOADURL, map[string]interface{}{
+		"drive_id":       driveId,
+		"file_id":        fileId,
+		"upload_id":      uploadId,
+		"part_info_list": partInfoList,
+	}, &rs)
+	if err != nil {
+		return nil, err
+	}
+	if len(rs.PartInfoList) != len(partNumbers) {
+		return nil, &APIError{StatusCode: http.StatusOK, Code: "InvalidPartInfoList", Message: "got " + strconv.Itoa(len(rs.PartInfoList)) + " upload urls for " + strconv.Itoa(len(partNumbers)) + " parts"}
+	}
+	return rs.PartInfoList, nil
 }
-func GetDownloadUrl(token string, driveId string, fileId string) string {
-
-	postData := make(map[string]interface{})
-	postData["drive_id"] = driveId
-	postData["file_id"] = fileId
-
-	data, _ := json.Marshal(postData)
-
-	body := net.Post(model.APIFILEDOWNLOAD, token, data)
-	return gjson.GetBytes(body, "url").Str
 
+// UploadFile uploads the size bytes of r starting at offset off as one part
+// to the signed URL url.
+func (c *Client) UploadFile(url string, r io.ReaderAt, off, size int64) error {
+	rs, status, err := net.PutAt(c.HTTPClient, url, r, off, size)
+	if err != nil {
+		return err
+	}
+	if status < 200 || status > 299 {
+		return newAPIError(status, rs)
+	}
+	return nil
 }
-func GetBoxSize(token string) (string, string) {
-
-	postData := make(map[string]interface{})
 
-	data, _ := json.Marshal(postData)
+// UploadFileComplete finishes the upload uploadId of the file fileId once
+// all of its parts have been uploaded.
+func (c *Client) UploadFileComplete(uploadId string, fileId string) (model.ListModel, error) {
+	_, driveId, err := c.credentials()
+	if err != nil {
+		return model.ListModel{}, err
+	}
+	var m model.ListModel
+	err = c.post(model.APIFILECOMPLETE, map[string]string{
+		"drive_id":  driveId,
+		"file_id":   fileId,
+		"upload_id": uploadId,
+	}, &m)
+	return m, err
+}
 
-	body := net.Post(model.APITOTLESIZE, token, data)
-	return gjson.GetBytes(body, "personal_space_info.total_size").String(), gjson.GetBytes(body, "personal_space_info.used_size").String()
+// GetDownloadUrl returns a signed URL the content of fileId can be
+// downloaded from.
+func (c *Client) GetDownloadUrl(fileId string) (string, error) {
+	_, driveId, err := c.credentials()
+	if err != nil {
+		return "", err
+	}
+	var rs struct {
+		Url string `json:"url"`
+	}
+	err = c.post(model.APIFILEDOWNLOAD, map[string]string{
+		"drive_id": driveId,
+		"file_id":  fileId,
+	}, &rs)
+	return rs.Url, err
+}
 
+// GetBoxSize returns the total and used space of the drive, in bytes.
+func (c *Client) GetBoxSize() (total int64, used int64, err error) {
+	var rs model.SpaceInfoModel
+	if err := c.post(model.APITOTLESIZE, map[string]string{}, &rs); err != nil {
+		return 0, 0, err
+	}
+	return rs.PersonalSpaceInfo.TotalSize, rs.PersonalSpaceInfo.UsedSize, nil
 }
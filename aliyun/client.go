@@ -0,0 +1,145 @@
+package aliyun
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-aliyun-webdav/aliyun/model"
+	"go-aliyun-webdav/aliyun/net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// A Client calls the AliyunDrive API on behalf of one account and drive.
+//
+// Concurrent use is permitted.
+type Client struct {
+	// HTTPClient sends the API requests. If nil, the client shared by
+	// package net is used, which retries transient failures.
+	HTTPClient *http.Client
+	// PartSize is the size of the parts uploads are split into. If zero,
+	// DefaultPartSize is used.
+	PartSize int64
+	// RapidUpload makes uploads from seekable sources send the SHA1 of
+	// their content first, so that content the drive already holds is not
+	// transferred again.
+	RapidUpload bool
+	// UploadWorkers is how many parts of a seekable source, such as a
+	// spooled body, are uploaded at once. Zero means one at a time.
+	UploadWorkers int
+	// Journal, if not nil, records the uploads in progress so that they
+	// can be recovered after a restart.
+	Journal *UploadJournal
+	// PermanentDelete makes Remove delete items for good instead of
+	// moving them to the recycle bin.
+	PermanentDelete bool
+
+	tokens TokenSource
+}
+
+// NewClient returns a Client that acts with the access token and drive
+// supplied by tokens.
+func NewClient(tokens TokenSource) *Client {
+	return &Client{tokens: tokens}
+}
+
+// credentials returns the access token and drive to act with. A client
+// without a TokenSource acts anonymously.
+func (c *Client) credentials() (token, driveId string, err error) {
+	if c.tokens == nil {
+		return "", "", nil
+	}
+	return c.tokens.Token()
+}
+
+// idempotent holds the API endpoints that only read, or that leave the
+// drive in the same state however often they are called. Requests to them
+// are retried after any transient failure; requests to other endpoints
+// only when they cannot have reached the server.
+var idempotent = map[string]bool{
+	model.APILISTURL:       true,
+	model.APIFILEUPDATE:    true,
+	model.APIFILEDETAIL:    true,
+	model.APIFILEUPLOADURL: true,
+	model.APIFILEDOWNLOAD:  true,
+	model.APITOTLESIZE:     true,
+}
+
+// post sends body as JSON to the API endpoint url and decodes the response
+// into v, unless v is nil. Responses with an error status are returned as an
+// *APIError.
+func (c *Client) post(url string, body interface{}, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	token, _, err := c.credentials()
+	if err != nil {
+		return err
+	}
+	rs, status, err := net.Post(c.HTTPClient, url, token, data, idempotent[url])
+	if err != nil {
+		return err
+	}
+	if status < 200 || status > 299 {
+		return newAPIError(status, rs)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(rs, v)
+}
+
+// An APIError is a failure reported by the AliyunDrive API or its storage
+// servers.
+type APIError struct {
+	// StatusCode is the HTTP status code of the API response.
+	StatusCode int
+	// Code and Message are the error code and description from the
+	// response body, e.g. "NotFound.File".
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func newAPIError(status int, body []byte) *APIError {
+	e := &APIError{StatusCode: status}
+	if json.Unmarshal(body, e) != nil || e.Code == "" {
+		e.Code = http.StatusText(status)
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("aliyun: %s: %s (HTTP %d)", e.Code, e.Message, e.StatusCode)
+}
+
+// Is reports whether e means that a file does not exist or already exists,
+// so that errors.Is(err, os.ErrNotExist) and errors.Is(err, os.ErrExist)
+// work on API errors.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case os.ErrNotExist:
+		return strings.HasPrefix(e.Code, "NotFound")
+	case os.ErrExist:
+		return strings.HasPrefix(e.Code, "AlreadyExist")
+	}
+	return false
+}
+
+// HTTPStatus returns the status code a WebDAV client should see for e.
+func (e *APIError) HTTPStatus() int {
+	switch {
+	case strings.HasPrefix(e.Code, "NotFound"):
+		return http.StatusNotFound
+	case strings.HasPrefix(e.Code, "AlreadyExist"):
+		return http.StatusConflict
+	case strings.HasPrefix(e.Code, "QuotaExhausted"):
+		return http.StatusInsufficientStorage
+	case e.StatusCode == http.StatusForbidden:
+		return http.StatusForbidden
+	case e.StatusCode == http.StatusTooManyRequests:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
@@ -0,0 +1,66 @@
+package aliyun
+
+import (
+	"sync"
+	"time"
+)
+
+// urlExpiryFallback is how long a download URL without an x-oss-expires
+// parameter is assumed to stay valid.
+const urlExpiryFallback = 10 * time.Minute
+
+// A urlCache remembers the signed download URL of each file ID until
+// urlExpiryMargin before it expires, so that the ranged requests of a video
+// player do not each cost a get_download_url call.
+type urlCache struct {
+	mu   sync.Mutex
+	urls map[string]cachedURL
+}
+
+type cachedURL struct {
+	url     string
+	expires int64 // Unix seconds
+}
+
+func newURLCache() *urlCache {
+	return &urlCache{urls: make(map[string]cachedURL)}
+}
+
+// get returns the cached URL of fileId, if it is still good for a while.
+func (c *urlCache) get(fileId string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.urls[fileId]
+	if !ok || time.Now().Add(urlExpiryMargin).Unix() >= u.expires {
+		return "", false
+	}
+	return u.url, true
+}
+
+// put caches url as the download URL of fileId.
+func (c *urlCache) put(fileId, url string) {
+	now := time.Now()
+	expires := urlExpiry(url)
+	if expires == 0 {
+		expires = now.Add(urlExpiryFallback).Unix()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.urls[fileId] = cachedURL{url: url, expires: expires}
+	if len(c.urls)%256 == 0 {
+		// Sweep now and then, so that files downloaded once do not
+		// pile up.
+		for id, u := range c.urls {
+			if u.expires <= now.Unix() {
+				delete(c.urls, id)
+			}
+		}
+	}
+}
+
+// drop forgets the URL of fileId, after it was rejected or the file changed.
+func (c *urlCache) drop(fileId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.urls, fileId)
+}
@@ -0,0 +1,580 @@
+package aliyun
+
+import (
+	"context"
+	"errors"
+	"go-aliyun-webdav/aliyun/model"
+	"go-aliyun-webdav/webdav"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rootItem stands in for the drive's root folder, which the list API never
+// returns as an item of its own.
+var rootItem = model.ListModel{
+	FileId: "root",
+	Name:   "/",
+	Type:   "folder",
+}
+
+// FileSystem implements webdav.FileSystem on top of an AliyunDrive account.
+type FileSystem struct {
+	client   *Client
+	resolver *Resolver
+	urls     *urlCache
+
+	quota struct {
+		mu              sync.Mutex
+		available, used int64
+		err             error
+		expires         time.Time
+	}
+}
+
+// NewFileSystem returns a FileSystem acting with the access token and drive
+// supplied by tokens.
+func NewFileSystem(tokens TokenSource) *FileSystem {
+	fs := &FileSystem{client: NewClient(tokens), urls: newURLCache()}
+	fs.resolver = NewResolver(fs.list)
+	return fs
+}
+
+// Client returns the client fs calls the API with, for tuning it.
+func (fs *FileSystem) Client() *Client {
+	return fs.client
+}
+
+var (
+	_ webdav.FileSystem    = (*FileSystem)(nil)
+	_ webdav.Putter        = (*FileSystem)(nil)
+	_ webdav.Copier        = (*FileSystem)(nil)
+	_ webdav.QuotaReporter = (*FileSystem)(nil)
+	_ webdav.Downloader    = (*file)(nil)
+	_ webdav.Redirector    = (*file)(nil)
+)
+
+// list returns the items in the folder parentFileId.
+func (fs *FileSystem) list(parentFileId string) ([]model.ListModel, error) {
+	return fs.client.GetList(parentFileId)
+}
+
+// lookup returns the item named by name.
+func (fs *FileSystem) lookup(op, name string) (model.ListModel, error) {
+	item, err := fs.resolver.Resolve(name)
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok {
+			pe.Op = op
+		}
+		return model.ListModel{}, err
+	}
+	return item, nil
+}
+
+// lookupParent returns the folder that contains name, along with the base
+// name of name within it.
+func (fs *FileSystem) lookupParent(op, name string) (model.ListModel, string, error) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return model.ListModel{}, "", &os.PathError{Op: op, Path: name, Err: os.ErrInvalid}
+	}
+	dir, base := path.Split(name)
+	parent, err := fs.lookup(op, dir)
+	if err != nil {
+		return model.ListModel{}, "", err
+	}
+	if parent.Type != "folder" {
+		return model.ListModel{}, "", &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return parent, base, nil
+}
+
+func (fs *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	parent, base, err := fs.lookupParent("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.lookup("mkdir", name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if _, err := fs.client.MakeDir(base, parent.FileId); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	fs.resolver.Invalidate(path.Dir(path.Clean("/" + name)))
+	return nil
+}
+
+func (fs *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		item, err := fs.lookup("open", name)
+		if err != nil {
+			return nil, err
+		}
+		return &file{fs: fs, name: name, item: item}, nil
+	}
+	if flag&os.O_APPEND != 0 {
+		// Drive files can only be replaced as a whole.
+		return nil, os.ErrInvalid
+	}
+	parent, base, err := fs.lookupParent("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fs.lookup("open", name); err == nil {
+		if flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+	} else if flag&os.O_CREATE == 0 {
+		return nil, err
+	}
+	return &uploadFile{fs: fs, path: name, parentId: parent.FileId, name: base}, nil
+}
+
+func (fs *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	item, err := fs.lookup("remove", name)
+	if os.IsNotExist(err) {
+		// Like os.RemoveAll, removing a missing path is not an error.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if item.FileId == rootItem.FileId {
+		// Prohibit removing the drive root.
+		return os.ErrInvalid
+	}
+	// A folder is removed as a whole, members included. An item that is
+	// gone already, removed by someone else meanwhile, counts as removed.
+	if err := fs.client.Remove(item.FileId); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	fs.urls.drop(item.FileId)
+	fs.resolver.Remove(name)
+	return nil
+}
+
+func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldName = path.Clean("/" + oldName)
+	newName = path.Clean("/" + newName)
+	if oldName == newName {
+		return nil
+	}
+	item, err := fs.lookup("rename", oldName)
+	if err != nil {
+		return err
+	}
+	if item.FileId == rootItem.FileId {
+		// Prohibit renaming the drive root.
+		return os.ErrInvalid
+	}
+	parent, base, err := fs.lookupParent("rename", newName)
+	if err != nil {
+		return err
+	}
+	if parent.FileId == item.ParentFileId {
+		_, err = fs.client.ReName(base, item.FileId)
+	} else {
+		// The move names the item as well, so that it never shows up
+		// under its old name in the new folder, where that name may be
+		// taken.
+		newBase := base
+		if newBase == item.Name {
+			newBase = ""
+		}
+		err = fs.client.MoveFile(item.FileId, parent.FileId, newBase)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+	fs.resolver.Invalidate(path.Dir(oldName))
+	fs.resolver.Invalidate(path.Dir(newName))
+	return nil
+}
+
+// Copy copies src to dst on the drive itself. Files are copied in one call.
+// For a folder, dst is created and, if recursive is set, the members of src
+// are copied into it in batches; members that fail are reported in a
+// *webdav.PartialError.
+func (fs *FileSystem) Copy(ctx context.Context, src, dst string, recursive bool) error {
+	src = path.Clean("/" + src)
+	dst = path.Clean("/" + dst)
+	item, err := fs.lookup("copy", src)
+	if err != nil {
+		return err
+	}
+	parent, base, err := fs.lookupParent("copy", dst)
+	if err != nil {
+		return err
+	}
+	defer fs.resolver.Invalidate(path.Dir(dst))
+	if item.Type != "folder" {
+		if err := fs.client.CopyFile(item.FileId, parent.FileId, base); err != nil {
+			return &os.LinkError{Op: "copy", Old: src, New: dst, Err: err}
+		}
+		return nil
+	}
+
+	dir, err := fs.client.MakeDir(base, parent.FileId)
+	if err != nil {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: err}
+	}
+	if !recursive {
+		return nil
+	}
+	children, err := fs.list(item.FileId)
+	if err != nil {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: err}
+	}
+	ids := make([]string, 0, len(children))
+	for _, c := range children {
+		ids = append(ids, c.FileId)
+	}
+	errs, err := fs.client.CopyFiles(ids, dir.FileId)
+	if err != nil {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: err}
+	}
+	fs.resolver.Invalidate(dst)
+	pe := &webdav.PartialError{Errs: make(map[string]error)}
+	for i, err := range errs {
+		if err != nil {
+			pe.Errs[path.Join(dst, children[i].Name)] = err
+		}
+	}
+	if len(pe.Errs) > 0 {
+		return pe
+	}
+	return nil
+}
+
+func (fs *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	item, err := fs.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{item}, nil
+}
+
+// Put uploads the size bytes read from r as the file name. The upload API
+// needs the size before the first byte is sent, so a body of unknown size
+// is spooled to a temporary file first. So is every body when rapid upload
+// is enabled, as its hash must be known up front as well, and when uploads
+// are journaled, so that they can be resumed after a restart.
+func (fs *FileSystem) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	parent, base, err := fs.lookupParent("put", name)
+	if err != nil {
+		return err
+	}
+	var spooled string
+	if _, ok := r.(io.ReaderAt); size < 0 || fs.client.RapidUpload && !ok || fs.client.Journal != nil {
+		f, n, err := spool(fs.spoolDir(), r)
+		if err != nil {
+			return &os.PathError{Op: "put", Path: name, Err: err}
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		r, size, spooled = f, n, f.Name()
+	}
+	return fs.upload(name, parent.FileId, base, r, size, spooled)
+}
+
+// spoolDir returns the directory bodies are spooled to: the upload journal,
+// where they survive a restart, or else the default temporary directory.
+func (fs *FileSystem) spoolDir() string {
+	if j := fs.client.Journal; j != nil {
+		return j.Dir
+	}
+	return ""
+}
+
+// spool copies r to a new temporary file in dir and returns it rewound,
+// along with its size. The caller removes the file.
+func spool(dir string, r io.Reader) (*os.File, int64, error) {
+	f, err := ioutil.TempFile(dir, spoolPrefix)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, err
+	}
+	return f, n, nil
+}
+
+// upload stores the size bytes read from r as the file base in the folder
+// parentId. name is the full path of the file. spooled names the spool file
+// r reads from, if any.
+func (fs *FileSystem) upload(name, parentId, base string, r io.Reader, size int64, spooled string) error {
+	var replaces string
+	if old, err := fs.resolver.Resolve(name); err == nil {
+		// The file is being replaced; its download URL may serve the old
+		// content.
+		replaces = old.FileId
+		defer fs.urls.drop(old.FileId)
+	}
+	err := fs.client.contentHandle(r, size, parentId, base, replaces, spooled)
+	fs.resolver.Invalidate(path.Dir(path.Clean("/" + name)))
+	if err != nil {
+		return &os.PathError{Op: "put", Path: name, Err: err}
+	}
+	return nil
+}
+
+// quotaTTL is how long the space figures of the drive, or the failure to get
+// them, are reused. A PROPFIND of a folder asks for them once per subfolder.
+const quotaTTL = 30 * time.Second
+
+// Quota returns the free and used space of the drive, in bytes.
+func (fs *FileSystem) Quota(ctx context.Context) (available, used int64, err error) {
+	fs.quota.mu.Lock()
+	defer fs.quota.mu.Unlock()
+	if time.Now().Before(fs.quota.expires) {
+		return fs.quota.available, fs.quota.used, fs.quota.err
+	}
+	total, used, err := fs.client.GetBoxSize()
+	fs.quota.available, fs.quota.used, fs.quota.err = total-used, used, err
+	fs.quota.expires = time.Now().Add(quotaTTL)
+	return fs.quota.available, fs.quota.used, err
+}
+
+// fileInfo implements os.FileInfo for a drive item. Its Sys method returns
+// the underlying model.ListModel.
+type fileInfo struct {
+	item model.ListModel
+}
+
+func (fi fileInfo) Name() string { return fi.item.Name }
+func (fi fileInfo) Size() int64  { return fi.item.Size }
+func (fi fileInfo) Mode() os.FileMode {
+	if fi.IsDir() {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+func (fi fileInfo) ModTime() time.Time { return fi.item.UpdatedAt }
+func (fi fileInfo) IsDir() bool        { return fi.item.Type == "folder" }
+func (fi fileInfo) Sys() interface{}   { return fi.item }
+
+// A file is a read-only webdav.File for a drive item. Reads are served by
+// ranged requests against the item's download URL, starting at the current
+// offset.
+type file struct {
+	fs   *FileSystem
+	name string
+	item model.ListModel
+	pos  int64
+	body io.ReadCloser
+	// children holds the folder listing, fetched by the first Readdir.
+	children []os.FileInfo
+	dirPos   int
+}
+
+// DownloadURL returns the signed URL the content of f can be downloaded
+// from. The storage servers only serve requests with an AliyunDrive Referer
+// header.
+func (f *file) DownloadURL(ctx context.Context) (string, error) {
+	return f.fs.downloadURL(f.item.FileId)
+}
+
+// downloadURL returns the download URL of fileId, from the cache if it is
+// good for a while yet.
+func (fs *FileSystem) downloadURL(fileId string) (string, error) {
+	if u, ok := fs.urls.get(fileId); ok {
+		return u, nil
+	}
+	u, err := fs.client.GetDownloadUrl(fileId)
+	if err != nil {
+		return "", err
+	}
+	fs.urls.put(fileId, u)
+	return u, nil
+}
+
+// Download requests the content of f from the drive, passing rangeStr and
+// ifRange on as the Range and If-Range headers.
+func (f *file) Download(ctx context.Context, rangeStr, ifRange string) (*http.Response, error) {
+	u, err := f.fs.downloadURL(f.item.FileId)
+	if err != nil {
+		return nil, err
+	}
+	res, err := f.fs.client.GetFile(ctx, u, rangeStr, ifRange)
+	if err != nil || res.StatusCode != http.StatusForbidden {
+		return res, err
+	}
+	// The URL expired earlier than it claimed; fetch a new one.
+	res.Body.Close()
+	f.fs.urls.drop(f.item.FileId)
+	if u, err = f.fs.downloadURL(f.item.FileId); err != nil {
+		return nil, err
+	}
+	return f.fs.client.GetFile(ctx, u, rangeStr, ifRange)
+}
+
+func (f *file) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.item.Type == "folder" {
+		return 0, os.ErrInvalid
+	}
+	if f.pos >= f.item.Size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		res, err := f.Download(context.TODO(), "bytes="+strconv.FormatInt(f.pos, 10)+"-", "")
+		if err != nil {
+			return 0, err
+		}
+		if res.StatusCode != http.StatusPartialContent && (res.StatusCode != http.StatusOK || f.pos != 0) {
+			res.Body.Close()
+			return 0, &APIError{StatusCode: res.StatusCode, Code: http.StatusText(res.StatusCode), Message: "unexpected download status"}
+		}
+		f.body = res.Body
+	}
+	n, err := f.body.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if f.item.Type != "folder" {
+		return nil, os.ErrInvalid
+	}
+	if f.children == nil {
+		items, err := f.fs.resolver.ReadDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.children = make([]os.FileInfo, 0, len(items))
+		for _, v := range items {
+			f.children = append(f.children, fileInfo{v})
+		}
+	}
+	old := f.dirPos
+	if old >= len(f.children) {
+		// The os.File Readdir docs say that at the end of a directory,
+		// the error is io.EOF if count > 0 and nil if count <= 0.
+		if count > 0 {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}
+	if count > 0 {
+		f.dirPos += count
+		if f.dirPos > len(f.children) {
+			f.dirPos = len(f.children)
+		}
+	} else {
+		f.dirPos = len(f.children)
+		old = 0
+	}
+	return f.children[old:f.dirPos], nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	npos := f.pos
+	switch whence {
+	case io.SeekStart:
+		npos = offset
+	case io.SeekCurrent:
+		npos += offset
+	case io.SeekEnd:
+		npos = f.item.Size + offset
+	default:
+		npos = -1
+	}
+	if npos < 0 {
+		return 0, os.ErrInvalid
+	}
+	if npos != f.pos {
+		// The open download no longer starts at the right offset.
+		f.Close()
+		f.pos = npos
+	}
+	return f.pos, nil
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f.item}, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// An uploadFile is a write-only webdav.File. Writes are spooled to a local
+// temporary file, which is uploaded to the drive on Close.
+type uploadFile struct {
+	fs       *FileSystem
+	path     string
+	parentId string
+	name     string
+	spool    *os.File
+	size     int64
+}
+
+func (f *uploadFile) Close() error {
+	if f.spool == nil {
+		return f.fs.upload(f.path, f.parentId, f.name, strings.NewReader(""), 0, "")
+	}
+	defer os.Remove(f.spool.Name())
+	defer f.spool.Close()
+	if _, err := f.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return f.fs.upload(f.path, f.parentId, f.name, f.spool, f.size, f.spool.Name())
+}
+
+func (f *uploadFile) Read(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *uploadFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *uploadFile) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekCurrent {
+		return f.size, nil
+	}
+	return 0, os.ErrInvalid
+}
+
+func (f *uploadFile) Stat() (os.FileInfo, error) {
+	return fileInfo{model.ListModel{
+		Name:         f.name,
+		Type:         "file",
+		ParentFileId: f.parentId,
+		Size:         f.size,
+		UpdatedAt:    time.Now(),
+	}}, nil
+}
+
+func (f *uploadFile) Write(p []byte) (int, error) {
+	if f.spool == nil {
+		spool, err := ioutil.TempFile(f.fs.spoolDir(), spoolPrefix)
+		if err != nil {
+			return 0, err
+		}
+		f.spool = spool
+	}
+	n, err := f.spool.Write(p)
+	f.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,8 @@
+package model
+
+type SpaceInfoModel struct {
+	PersonalSpaceInfo struct {
+		TotalSize int64 `json:"total_size"`
+		UsedSize  int64 `json:"used_size"`
+	} `json:"personal_space_info"`
+}
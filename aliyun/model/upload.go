@@ -0,0 +1,16 @@
+package model
+
+type PartInfo struct {
+	PartNumber int    `json:"part_number"`
+	UploadUrl  string `json:"upload_url"`
+}
+
+type UploadModel struct {
+	ParentFileId string     `json:"parent_file_id"`
+	PartInfoList []PartInfo `json:"part_info_list"`
+	UploadId     string     `json:"upload_id"`
+	RapidUpload  bool       `json:"rapid_upload"`
+	Type         string     `json:"type"`
+	FileId       string     `json:"file_id"`
+	FileName     string     `json:"file_name"`
+}
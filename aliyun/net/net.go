@@ -2,148 +2,113 @@ package net
 
 import (
 	"bytes"
-	"fmt"
+	"context"
 	"io"
-	"io/ioutil"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
+	"time"
 )
 
-func Post(url, token string, data []byte) []byte {
-	method := "POST"
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(data))
-
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-	req.Header.Add("accept", "application/json, text/plain, */*")
-	req.Header.Add("user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/92.0.4515.159 Safari/537.36")
-	req.Header.Add("content-type", "application/json;charset=UTF-8")
-	req.Header.Add("origin", "https://www.aliyundrive.com")
-	req.Header.Add("referer", "https://www.aliyundrive.com/")
-	req.Header.Add("Authorization", "Bearer "+token)
-
-	res, err := client.Do(req)
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-	defer res.Body.Close()
-
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-	return body
+// Post sends data as a JSON API request authorized by token and returns
+// the response body along with its HTTP status code. A nil client means the
+// shared client configured by Configure. Transient failures are retried;
+// unless idempotent is set, only those where the request cannot have been
+// acted upon.
+func Post(client *http.Client, url, token string, data []byte, idempotent bool) ([]byte, int, error) {
+	return do(client, true, idempotent, func(ctx context.Context) (*http.Request, error) {
+		method := "POST"
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("accept", "application/json, text/plain, */*")
+		req.Header.Add("user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/92.0.4515.159 Safari/537.36")
+		req.Header.Add("content-type", "application/json;charset=UTF-8")
+		req.Header.Add("origin", "https://www.aliyundrive.com")
+		req.Header.Add("referer", "https://www.aliyundrive.com/")
+		if len(token) > 0 {
+			req.Header.Add("Authorization", "Bearer "+token)
+		}
+		return req, nil
+	})
 }
-func Put(url, token string, data []byte) []byte {
-	method := "PUT"
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(data))
-
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-
-	res, err := client.Do(req)
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-	defer res.Body.Close()
 
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-	return body
+// Put uploads data to a signed upload URL and returns the response body
+// along with its HTTP status code. A nil client means the shared client
+// configured by Configure. Transient failures are retried.
+func Put(client *http.Client, url string, data []byte) ([]byte, int, error) {
+	return PutAt(client, url, bytes.NewReader(data), 0, int64(len(data)))
 }
-func Get(w http.ResponseWriter, url, token string, rangeStr string, ifRange string) bool {
-
-	method := "GET"
 
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, nil)
-
-	if err != nil {
-		fmt.Println(err)
-		return false
-	}
-	//req.Header.Add("accept", "application/json, text/plain, */*")
-	//req.Header.Add("user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/92.0.4515.159 Safari/537.36")
-	//req.Header.Add("content-type", "application/json;charset=UTF-8")
-	//req.Header.Add("origin", "https://www.aliyundrive.com")
-	req.Header.Add("referer", "https://www.aliyundrive.com/")
-	req.Header.Add("Authorization", "Bearer "+token)
-	req.Header.Add("range", rangeStr)
-	req.Header.Add("if-range", ifRange)
-
-	res, err := client.Do(req)
-	if err != nil {
-		fmt.Println(err)
-		return false
-	}
-	io.Copy(w, res.Body)
-	res.Body.Close()
-	return true
-
-	//	body, err := ioutil.ReadAll(res.Body)
-	//	if len(body) == 0 {
-	//		fmt.Println("获取详情报错")
-	//	}
-	//	if err != nil {
-	//		fmt.Println(err)
-	//		return nil
-	//	}
-	//	return body
+// PutAt is like Put but uploads the n bytes of r starting at offset off.
+// The section is read anew for every attempt, so it is streamed into the
+// request and can still be retried.
+func PutAt(client *http.Client, url string, r io.ReaderAt, off, n int64) ([]byte, int, error) {
+	return do(client, false, true, func(ctx context.Context) (*http.Request, error) {
+		method := "PUT"
+		req, err := http.NewRequestWithContext(ctx, method, url, io.NewSectionReader(r, off, n))
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = n
+		return req, nil
+	})
 }
-func GetProxy(w http.ResponseWriter, req *http.Request, urlStr, token string) []byte {
 
-	//method := "GET"
-	u, _ := url.Parse(urlStr)
-	proxy := httputil.ReverseProxy{
-		Director: func(request *http.Request) {
-			request.URL = u
-			request.Header.Add("referer", "https://www.aliyundrive.com/")
-			request.Header.Add("Authorization", "Bearer "+token)
-		},
+// Get requests a signed download URL, passing rangeStr and ifRange on as
+// the Range and If-Range headers. The caller must close the response body.
+// A nil client means the shared client configured by Configure. Failures
+// before the response arrives, and 5xx or 429 responses, are retried; the
+// body itself is streamed and never retried. Once ctx is done, no further
+// attempt is made.
+func Get(ctx context.Context, client *http.Client, url, token string, rangeStr string, ifRange string) (*http.Response, error) {
+	opts, c := settings()
+	if client == nil {
+		client = c
+	}
+	b := backoff{opts: opts}
+	for {
+		method := "GET"
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		//req.Header.Add("accept", "application/json, text/plain, */*")
+		//req.Header.Add("user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/92.0.4515.159 Safari/537.36")
+		//req.Header.Add("content-type", "application/json;charset=UTF-8")
+		//req.Header.Add("origin", "https://www.aliyundrive.com")
+		req.Header.Add("referer", "https://www.aliyundrive.com/")
+		req.Header.Add("Authorization", "Bearer "+token)
+		if len(rangeStr) > 0 {
+			req.Header.Add("range", rangeStr)
+		}
+		if len(ifRange) > 0 {
+			req.Header.Add("if-range", ifRange)
+		}
+		res, err := client.Do(req)
+		status := 0
+		if res != nil {
+			status = res.StatusCode
+		}
+		if !retryable(status, err, true) {
+			return res, err
+		}
+		var wait time.Duration
+		if res != nil {
+			wait = retryAfter(res.Header)
+		}
+		delay, ok := b.next(wait)
+		if !ok {
+			return res, err
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
 	}
-	proxy.ServeHTTP(w, req)
-	//	client := &http.Client{}
-	return []byte{}
-	//	req, err := http.NewRequest(method, url, nil)
-	//
-	//	if err != nil {
-	//		fmt.Println(err)
-	//		return nil
-	//	}
-	//	//req.Header.Add("accept", "application/json, text/plain, */*")
-	//	//req.Header.Add("user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/92.0.4515.159 //Safari/537.36")
-	//	//req.Header.Add("content-type", "application/json;charset=UTF-8")
-	//	//req.Header.Add("origin", "https://www.aliyundrive.com")
-	//	req.Header.Add("referer", "https://www.aliyundrive.com/")
-	//	req.Header.Add("Authorization", "Bearer "+token)
-	//
-	//	res, err := client.Do(req)
-	//	if err != nil {
-	//		fmt.Println(err)
-	//		return nil
-	//	}
-	//	defer res.Body.Close()
-	//
-	//	body, err := ioutil.ReadAll(res.Body)
-	//	if len(body) == 0 {
-	//		fmt.Println("获取详情报错")
-	//	}
-	//	if err != nil {
-	//		fmt.Println(err)
-	//		return nil
-	//	}
-	//	return body
 }
@@ -0,0 +1,236 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	gonet "net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Options configures the transport shared by all requests and how failed
+// requests are retried.
+type Options struct {
+	// DialTimeout bounds establishing a connection, including the TLS
+	// handshake.
+	DialTimeout time.Duration
+	// ResponseHeaderTimeout bounds waiting for the response headers once a
+	// request has been written.
+	ResponseHeaderTimeout time.Duration
+	// RequestTimeout bounds one attempt of an API request made by Post,
+	// including reading the response body. Zero means no limit. Uploads and
+	// downloads are only bounded by ResponseHeaderTimeout, since their
+	// duration depends on the size of the content.
+	RequestTimeout time.Duration
+
+	// MaxRetries is how often a request failing with a connection error, a
+	// 5xx or a 429 status is retried. Requests that are not idempotent are
+	// only retried when they cannot have reached the server: after a
+	// failure to connect, or a 429 status.
+	MaxRetries int
+	// MinBackoff is the delay before the first retry. It doubles with every
+	// further retry, up to MaxBackoff, and is jittered to spread out
+	// clients failing at the same time. A Retry-After header sent by the
+	// server takes precedence when it asks for a longer delay.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// RetryBudget bounds the total time spent waiting between the retries
+	// of one request. A retry that would exceed it is not attempted.
+	RetryBudget time.Duration
+}
+
+// DefaultOptions are the options in effect until Configure is called.
+var DefaultOptions = Options{
+	DialTimeout:           10 * time.Second,
+	ResponseHeaderTimeout: 30 * time.Second,
+	RequestTimeout:        60 * time.Second,
+	MaxRetries:            3,
+	MinBackoff:            500 * time.Millisecond,
+	MaxBackoff:            10 * time.Second,
+	RetryBudget:           30 * time.Second,
+}
+
+var (
+	mu      sync.RWMutex
+	options = DefaultOptions
+	shared  = newClient(DefaultOptions)
+)
+
+// Configure replaces the options used by subsequent requests. Requests made
+// with a nil client switch to a new shared transport built from opts.
+func Configure(opts Options) {
+	client := newClient(opts)
+	mu.Lock()
+	defer mu.Unlock()
+	options, shared = opts, client
+}
+
+func settings() (Options, *http.Client) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return options, shared
+}
+
+func newClient(opts Options) *http.Client {
+	dialer := &gonet.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   16,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   opts.DialTimeout,
+			ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
+
+// do sends the request built by newRequest and returns the response body
+// along with its HTTP status code. Transient failures are retried with a
+// request built anew, so newRequest must return a fresh body every time.
+// Unless idempotent is set, only failures that the server cannot have acted
+// upon are retried. Once the retries are used up, the last response or
+// error is returned.
+func do(client *http.Client, timeout, idempotent bool, newRequest func(ctx context.Context) (*http.Request, error)) ([]byte, int, error) {
+	opts, c := settings()
+	if client == nil {
+		client = c
+	}
+	b := backoff{opts: opts}
+	for {
+		body, status, retryAfter, err := attempt(client, opts, timeout, newRequest)
+		if !retryable(status, err, idempotent) {
+			return body, status, err
+		}
+		delay, ok := b.next(retryAfter)
+		if !ok {
+			return body, status, err
+		}
+		time.Sleep(delay)
+	}
+}
+
+func attempt(client *http.Client, opts Options, timeout bool, newRequest func(ctx context.Context) (*http.Request, error)) ([]byte, int, time.Duration, error) {
+	ctx := context.Background()
+	if timeout && opts.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.RequestTimeout)
+		defer cancel()
+	}
+	req, err := newRequest(ctx)
+	if err != nil {
+		return nil, 0, 0, errPermanent{err}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	return body, res.StatusCode, retryAfter(res.Header), err
+}
+
+// errPermanent marks an error that retrying cannot fix.
+type errPermanent struct{ error }
+
+func (e errPermanent) Unwrap() error { return e.error }
+
+// retryable reports whether a request that ended with status and err may
+// succeed when sent again. A request that is not idempotent may only be
+// sent again if the first one was turned away without being acted upon.
+func retryable(status int, err error, idempotent bool) bool {
+	if err != nil {
+		var p errPermanent
+		if errors.As(err, &p) || errors.Is(err, context.Canceled) {
+			return false
+		}
+		return idempotent || !sent(err)
+	}
+	if status == http.StatusTooManyRequests {
+		return true
+	}
+	if !idempotent {
+		return false
+	}
+	switch status {
+	case http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// sent reports whether a request that failed with err may have reached the
+// server. Only a failure to connect proves that it did not.
+func sent(err error) bool {
+	var op *gonet.OpError
+	return !errors.As(err, &op) || op.Op != "dial"
+}
+
+// retryAfter returns the delay asked for by the Retry-After header h, which
+// holds either a number of seconds or an HTTP date. It returns 0 if h has
+// no usable value.
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// backoff computes the delays between the retries of one request.
+type backoff struct {
+	opts    Options
+	retries int
+	waited  time.Duration
+}
+
+// next returns the delay before the next retry, given the delay asked for
+// by the server, or false if the retries or the retry budget are used up.
+func (b *backoff) next(retryAfter time.Duration) (time.Duration, bool) {
+	if b.retries >= b.opts.MaxRetries {
+		return 0, false
+	}
+	d := b.opts.MinBackoff << uint(b.retries)
+	if d > b.opts.MaxBackoff || d <= 0 {
+		d = b.opts.MaxBackoff
+	}
+	if d > 0 {
+		// Equal jitter: wait at least half of the backoff, so that
+		// retries still slow down while not moving in lockstep.
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	if retryAfter > d {
+		d = retryAfter
+	}
+	if b.waited+d > b.opts.RetryBudget {
+		return 0, false
+	}
+	b.retries++
+	b.waited += d
+	return d, true
+}
@@ -0,0 +1,258 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	gonet "net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testOptions retry quickly, so that the tests do not wait for long.
+var testOptions = Options{
+	MaxRetries:  3,
+	MinBackoff:  time.Millisecond,
+	MaxBackoff:  2 * time.Millisecond,
+	RetryBudget: time.Second,
+}
+
+func configure(t *testing.T, opts Options) {
+	Configure(opts)
+	t.Cleanup(func() { Configure(DefaultOptions) })
+}
+
+// script serves the responses in order, repeating the last one, and counts
+// the requests. A status of 0 resets the connection instead.
+type script struct {
+	calls    int32
+	statuses []int
+	header   http.Header
+}
+
+func (s *script) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i := int(atomic.AddInt32(&s.calls, 1)) - 1
+	if i >= len(s.statuses) {
+		i = len(s.statuses) - 1
+	}
+	status := s.statuses[i]
+	if status == 0 {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+	for k, v := range s.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(http.StatusText(status)))
+}
+
+func post(client *http.Client, url string, idempotent bool) ([]byte, int, error) {
+	return Post(client, url, "token", []byte(`{}`), idempotent)
+}
+
+func TestDo(t *testing.T) {
+	configure(t, testOptions)
+	tests := []struct {
+		name       string
+		statuses   []int
+		idempotent bool
+		wantCalls  int32
+		wantStatus int
+		wantErr    bool
+	}{
+		{"success", []int{200}, false, 1, 200, false},
+		{"429", []int{429, 200}, false, 2, 200, false},
+		{"5xx idempotent", []int{500, 502, 200}, true, 3, 200, false},
+		{"5xx not idempotent", []int{500, 200}, false, 1, 500, false},
+		{"5xx persistent", []int{503}, true, 4, 503, false},
+		{"4xx", []int{404, 200}, true, 1, 404, false},
+		{"reset idempotent", []int{0, 200}, true, 2, 200, false},
+		{"reset not idempotent", []int{0, 200}, false, 1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &script{statuses: tt.statuses}
+			srv := httptest.NewServer(s)
+			defer srv.Close()
+			body, status, err := post(srv.Client(), srv.URL, tt.idempotent)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if err == nil && string(body) != http.StatusText(status) {
+				t.Errorf("body = %q", body)
+			}
+			if calls := atomic.LoadInt32(&s.calls); calls != tt.wantCalls {
+				t.Errorf("%d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDoPutAt(t *testing.T) {
+	configure(t, testOptions)
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		got = append(got, string(b))
+		if len(got) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+	_, status, err := PutAt(srv.Client(), srv.URL, strings.NewReader("0123456789"), 2, 5)
+	if err != nil || status != http.StatusOK {
+		t.Fatal(status, err)
+	}
+	if len(got) != 2 || got[0] != "23456" || got[1] != "23456" {
+		t.Fatalf("bodies = %q", got)
+	}
+}
+
+func TestDoConnectionRefused(t *testing.T) {
+	configure(t, testOptions)
+	l, err := gonet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var dials int32
+	dialer := &gonet.Dialer{}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (gonet.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}}
+	// The request cannot have been sent, so even a request that is not
+	// idempotent is retried.
+	_, _, err = post(client, "http://"+addr, false)
+	var op *gonet.OpError
+	if !errors.As(err, &op) || op.Op != "dial" {
+		t.Fatalf("err = %v, want a dial error", err)
+	}
+	if want := int32(testOptions.MaxRetries + 1); dials != want {
+		t.Errorf("%d dials, want %d", dials, want)
+	}
+}
+
+func TestDoRetryBudget(t *testing.T) {
+	opts := testOptions
+	opts.RetryBudget = 1500 * time.Millisecond
+	configure(t, opts)
+	s := &script{statuses: []int{429}, header: http.Header{"Retry-After": {"1"}}}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	start := time.Now()
+	_, status, err := post(srv.Client(), srv.URL, false)
+	if err != nil || status != http.StatusTooManyRequests {
+		t.Fatal(status, err)
+	}
+	// The second retry would wait beyond the budget, so it is not made.
+	if calls := atomic.LoadInt32(&s.calls); calls != 2 {
+		t.Errorf("%d calls, want 2", calls)
+	}
+	if d := time.Since(start); d < time.Second || d > opts.RetryBudget {
+		t.Errorf("took %v", d)
+	}
+}
+
+func TestBackoffNext(t *testing.T) {
+	b := backoff{opts: Options{
+		MaxRetries:  5,
+		MinBackoff:  100 * time.Millisecond,
+		MaxBackoff:  400 * time.Millisecond,
+		RetryBudget: time.Minute,
+	}}
+	for i, max := range []time.Duration{100, 200, 400, 400, 400} {
+		max *= time.Millisecond
+		d, ok := b.next(0)
+		if !ok || d < max/2 || d > max {
+			t.Errorf("retry %d: %v, %t; want within [%v, %v]", i, d, ok, max/2, max)
+		}
+	}
+	if d, ok := b.next(0); ok {
+		t.Errorf("retry after MaxRetries: %v", d)
+	}
+
+	b = backoff{opts: Options{
+		MaxRetries:  5,
+		MinBackoff:  100 * time.Millisecond,
+		MaxBackoff:  time.Second,
+		RetryBudget: 5 * time.Second,
+	}}
+	if d, ok := b.next(3 * time.Second); !ok || d != 3*time.Second {
+		t.Errorf("Retry-After: %v, %t; want 3s", d, ok)
+	}
+	if d, ok := b.next(3 * time.Second); ok {
+		t.Errorf("retry beyond budget: %v", d)
+	}
+	if d, ok := b.next(0); !ok || d > 200*time.Millisecond {
+		t.Errorf("retry within budget: %v, %t", d, ok)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"7", 7 * time.Second, 7 * time.Second},
+		{"0", 0, 0},
+		{"-3", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 59 * time.Minute, time.Hour},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.value != "" {
+			h.Set("Retry-After", tt.value)
+		}
+		if d := retryAfter(h); d < tt.min || d > tt.max {
+			t.Errorf("retryAfter(%q) = %v, want within [%v, %v]", tt.value, d, tt.min, tt.max)
+		}
+	}
+}
+
+func TestGetCanceled(t *testing.T) {
+	opts := testOptions
+	opts.RetryBudget = time.Minute
+	configure(t, opts)
+	s := &script{statuses: []int{503}, header: http.Header{"Retry-After": {"30"}}}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	// The client went away while Get waited to retry, so it gives up at
+	// once.
+	res, err := Get(ctx, srv.Client(), srv.URL, "token", "", "")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("res = %v, err = %v; want canceled", res, err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("took %v", d)
+	}
+	if calls := atomic.LoadInt32(&s.calls); calls != 1 {
+		t.Errorf("%d calls, want 1", calls)
+	}
+}
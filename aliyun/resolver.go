@@ -0,0 +1,207 @@
+package aliyun
+
+import (
+	"go-aliyun-webdav/aliyun/model"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Resolver maps slash-separated drive paths to the items they name.
+//
+// Folder listings are kept in a tree index, so each folder on a path is only
+// listed once per TTL. Callers that change the drive report the change with
+// Invalidate or Remove, which update just the affected listing.
+//
+// Concurrent use is permitted. Listings are fetched without holding the
+// index lock.
+type Resolver struct {
+	// TTL is how long a folder listing is trusted before it is fetched
+	// again, so that changes made outside of this process show up.
+	TTL time.Duration
+
+	list func(parentFileId string) ([]model.ListModel, error)
+
+	mu   sync.Mutex
+	root *node
+}
+
+// A node is an indexed drive item. The children of a folder are nil until
+// the folder has been listed. All fields are guarded by Resolver.mu; the
+// children map is never modified once set, but replaced, so that a
+// snapshot of it can be read without the lock.
+type node struct {
+	item     model.ListModel
+	children map[string]*node
+	order    []*node
+	expires  time.Time
+}
+
+// NewResolver returns a Resolver that lists folders with list.
+func NewResolver(list func(parentFileId string) ([]model.ListModel, error)) *Resolver {
+	return &Resolver{
+		TTL:  5 * time.Minute,
+		list: list,
+		root: &node{item: rootItem},
+	}
+}
+
+// Resolve returns the item named by name. It returns an error satisfying
+// os.IsNotExist if there is no such item.
+func (r *Resolver) Resolve(name string) (model.ListModel, error) {
+	n, err := r.find(name)
+	if err != nil {
+		return model.ListModel{}, err
+	}
+	return r.item(n), nil
+}
+
+// ReadDir returns the items in the folder name, in the order the drive
+// listed them.
+func (r *Resolver) ReadDir(name string) ([]model.ListModel, error) {
+	n, err := r.find(name)
+	if err != nil {
+		return nil, err
+	}
+	if r.item(n).Type != "folder" {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrInvalid}
+	}
+	_, order, err := r.fill(n)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	items := make([]model.ListModel, 0, len(order))
+	for _, c := range order {
+		items = append(items, c.item)
+	}
+	return items, nil
+}
+
+// Invalidate expires the listing of the folder name, if indexed, so that
+// the next lookup below it lists the folder again. Until then the old
+// listing is still served to lookups already under way. Use it after
+// adding to or replacing within a folder.
+func (r *Resolver) Invalidate(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n := r.peek(name); n != nil {
+		n.expires = time.Time{}
+	}
+}
+
+// Remove drops name, and everything indexed below it, from the index.
+func (r *Resolver) Remove(name string) {
+	dir, base := path.Split(path.Clean("/" + name))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	parent := r.peek(dir)
+	if parent == nil || parent.children == nil {
+		return
+	}
+	c, ok := parent.children[base]
+	if !ok {
+		return
+	}
+	children := make(map[string]*node, len(parent.children))
+	for k, v := range parent.children {
+		if k != base {
+			children[k] = v
+		}
+	}
+	parent.children = children
+	for i, o := range parent.order {
+		if o == c {
+			parent.order = append(parent.order[:i:i], parent.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// find walks name from the root, listing folders as needed.
+func (r *Resolver) find(name string) (*node, error) {
+	name = path.Clean("/" + name)
+	n := r.root
+	if name == "/" {
+		return n, nil
+	}
+	for _, frag := range strings.Split(name[1:], "/") {
+		if r.item(n).Type != "folder" {
+			return nil, &os.PathError{Op: "resolve", Path: name, Err: os.ErrNotExist}
+		}
+		children, _, err := r.fill(n)
+		if err != nil {
+			return nil, &os.PathError{Op: "resolve", Path: name, Err: err}
+		}
+		c, ok := children[frag]
+		if !ok {
+			return nil, &os.PathError{Op: "resolve", Path: name, Err: os.ErrNotExist}
+		}
+		n = c
+	}
+	return n, nil
+}
+
+// item returns the drive item of n.
+func (r *Resolver) item(n *node) model.ListModel {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return n.item
+}
+
+// fill lists the folder n unless its listing is indexed and fresh. Nodes of
+// children that are still present are kept, along with their own listings.
+// It returns the listing as it was when fill was done with it, which later
+// changes to the index leave alone.
+func (r *Resolver) fill(n *node) (map[string]*node, []*node, error) {
+	r.mu.Lock()
+	children, order := n.children, n.order
+	fresh := children != nil && time.Now().Before(n.expires)
+	fileId := n.item.FileId
+	r.mu.Unlock()
+	if fresh {
+		return children, order, nil
+	}
+	items, err := r.list(fileId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	children = make(map[string]*node, len(items))
+	order = make([]*node, 0, len(items))
+	for _, item := range items {
+		c, ok := n.children[item.Name]
+		if ok && c.item.FileId == item.FileId {
+			c.item = item
+		} else {
+			c = &node{item: item}
+		}
+		children[item.Name] = c
+		order = append(order, c)
+	}
+	n.children, n.order = children, order
+	n.expires = time.Now().Add(r.TTL)
+	return children, order, nil
+}
+
+// peek returns the indexed node for name without listing anything, or nil
+// if name is not indexed. r.mu must be held.
+func (r *Resolver) peek(name string) *node {
+	name = path.Clean("/" + name)
+	n := r.root
+	if name == "/" {
+		return n
+	}
+	for _, frag := range strings.Split(name[1:], "/") {
+		n = n.children[frag]
+		if n == nil {
+			return nil
+		}
+	}
+	return n
+}
@@ -0,0 +1,240 @@
+package aliyun
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"go-aliyun-webdav/aliyun/model"
+)
+
+// fakeLister serves folder listings from a map of parent file IDs to items
+// and counts how often each folder is listed.
+type fakeLister struct {
+	mu     sync.Mutex
+	items  map[string][]model.ListModel
+	listed map[string]int
+}
+
+func newFakeLister() *fakeLister {
+	return &fakeLister{
+		items: map[string][]model.ListModel{
+			"root": {
+				{FileId: "a", Name: "a", Type: "folder"},
+				{FileId: "f", Name: "f.txt", Type: "file"},
+			},
+			"a": {
+				{FileId: "b", Name: "b", Type: "folder"},
+				{FileId: "x", Name: "x.txt", Type: "file"},
+			},
+			"b": {
+				{FileId: "y", Name: "y.txt", Type: "file"},
+			},
+		},
+		listed: map[string]int{},
+	}
+}
+
+func (l *fakeLister) list(parentFileId string) ([]model.ListModel, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.listed[parentFileId]++
+	return append([]model.ListModel(nil), l.items[parentFileId]...), nil
+}
+
+func (l *fakeLister) set(parentFileId string, items ...model.ListModel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.items[parentFileId] = items
+}
+
+func (l *fakeLister) count(parentFileId string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.listed[parentFileId]
+}
+
+func TestResolverResolve(t *testing.T) {
+	l := newFakeLister()
+	r := NewResolver(l.list)
+	tests := []struct {
+		name   string
+		fileId string
+	}{
+		{"/", "root"},
+		{"", "root"},
+		{"/a", "a"},
+		{"/a/", "a"},
+		{"a/b/y.txt", "y"},
+		{"/a/../f.txt", "f"},
+	}
+	for _, tt := range tests {
+		item, err := r.Resolve(tt.name)
+		if err != nil || item.FileId != tt.fileId {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", tt.name, item.FileId, err, tt.fileId)
+		}
+	}
+	for _, name := range []string{"/missing", "/a/missing", "/f.txt/below", "/a/x.txt/below"} {
+		if _, err := r.Resolve(name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Resolve(%q): %v, want not exist", name, err)
+		}
+	}
+	// Every folder was listed once, however often it was walked.
+	for _, id := range []string{"root", "a", "b"} {
+		if n := l.count(id); n != 1 {
+			t.Errorf("%s listed %d times", id, n)
+		}
+	}
+}
+
+func TestResolverReadDir(t *testing.T) {
+	l := newFakeLister()
+	l.set("root",
+		model.ListModel{FileId: "3", Name: "c", Type: "file"},
+		model.ListModel{FileId: "1", Name: "a", Type: "file"},
+		model.ListModel{FileId: "2", Name: "b", Type: "file"},
+	)
+	r := NewResolver(l.list)
+	items, err := r.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names string
+	for _, item := range items {
+		names += item.Name
+	}
+	if names != "cab" {
+		t.Errorf("ReadDir order %q, want the listed order %q", names, "cab")
+	}
+	if _, err := r.ReadDir("/a"); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("ReadDir of a file: %v, want invalid", err)
+	}
+}
+
+func TestResolverRemove(t *testing.T) {
+	l := newFakeLister()
+	r := NewResolver(l.list)
+	if _, err := r.Resolve("/a/b/y.txt"); err != nil {
+		t.Fatal(err)
+	}
+	r.Remove("/a/b")
+	if _, err := r.Resolve("/a/b"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Resolve after Remove: %v", err)
+	}
+	items, err := r.ReadDir("/a")
+	if err != nil || len(items) != 1 || items[0].Name != "x.txt" {
+		t.Errorf("ReadDir after Remove = %v, %v", items, err)
+	}
+	if n := l.count("a"); n != 1 {
+		t.Errorf("Remove relisted the folder: %d listings", n)
+	}
+	// Removing what is not indexed does nothing.
+	r.Remove("/missing/z")
+	r.Remove("/a/missing")
+}
+
+func TestResolverInvalidate(t *testing.T) {
+	l := newFakeLister()
+	r := NewResolver(l.list)
+	if _, err := r.Resolve("/a/x.txt"); err != nil {
+		t.Fatal(err)
+	}
+	l.set("a",
+		model.ListModel{FileId: "b", Name: "b", Type: "folder"},
+		model.ListModel{FileId: "z", Name: "z.txt", Type: "file"},
+	)
+	// Within the TTL the old listing is served.
+	if _, err := r.Resolve("/a/z.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Resolve before Invalidate: %v", err)
+	}
+	r.Invalidate("/a")
+	if item, err := r.Resolve("/a/z.txt"); err != nil || item.FileId != "z" {
+		t.Errorf("Resolve after Invalidate = %v, %v", item, err)
+	}
+	if _, err := r.Resolve("/a/x.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Resolve of a vanished item: %v", err)
+	}
+	if n := l.count("a"); n != 2 {
+		t.Errorf("a listed %d times, want 2", n)
+	}
+	if n := l.count("root"); n != 1 {
+		t.Errorf("root listed %d times, want 1", n)
+	}
+}
+
+func TestResolverTTL(t *testing.T) {
+	l := newFakeLister()
+	r := NewResolver(l.list)
+	r.TTL = time.Millisecond
+	r.Resolve("/a")
+	time.Sleep(5 * time.Millisecond)
+	r.Resolve("/a")
+	if n := l.count("root"); n != 2 {
+		t.Errorf("root listed %d times, want 2", n)
+	}
+}
+
+func TestResolverKeepsChildren(t *testing.T) {
+	l := newFakeLister()
+	r := NewResolver(l.list)
+	if _, err := r.Resolve("/a/b/y.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Relisting a folder keeps the listings of subfolders whose FileId is
+	// unchanged, and takes their updated items.
+	l.set("a",
+		model.ListModel{FileId: "b", Name: "b", Type: "folder", Size: 7},
+		model.ListModel{FileId: "x", Name: "x.txt", Type: "file"},
+	)
+	r.Invalidate("/a")
+	if item, err := r.Resolve("/a/b"); err != nil || item.Size != 7 {
+		t.Fatalf("Resolve(/a/b) = %v, %v", item, err)
+	}
+	if _, err := r.Resolve("/a/b/y.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if n := l.count("b"); n != 1 {
+		t.Errorf("b listed %d times, want 1", n)
+	}
+
+	// A folder replaced under the same name is listed anew.
+	l.set("a", model.ListModel{FileId: "b2", Name: "b", Type: "folder"})
+	l.set("b2", model.ListModel{FileId: "w", Name: "w.txt", Type: "file"})
+	r.Invalidate("/a")
+	if _, err := r.Resolve("/a/b/y.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Resolve in the replaced folder: %v", err)
+	}
+	if item, err := r.Resolve("/a/b/w.txt"); err != nil || item.FileId != "w" {
+		t.Errorf("Resolve(/a/b/w.txt) = %v, %v", item, err)
+	}
+}
+
+func TestResolverConcurrent(t *testing.T) {
+	l := newFakeLister()
+	r := NewResolver(l.list)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				// Invalidating never makes an existing item
+				// disappear for a lookup under way.
+				if _, err := r.Resolve("/a/b/y.txt"); err != nil {
+					t.Error(err)
+					return
+				}
+				if items, err := r.ReadDir("/a"); err != nil || len(items) != 2 {
+					t.Error(items, err)
+					return
+				}
+				r.Invalidate("/a")
+				r.Invalidate("/")
+			}
+		}()
+	}
+	wg.Wait()
+}
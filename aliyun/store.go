@@ -0,0 +1,150 @@
+package aliyun
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvRefreshToken is the environment variable EnvStore reads the refresh
+// token from by default.
+const EnvRefreshToken = "ALIYUN_REFRESH_TOKEN"
+
+// A TokenStore keeps the refresh token across restarts. Refresh tokens are
+// single-use: every refresh hands out a new one, which must be saved or the
+// next start fails.
+type TokenStore interface {
+	// Load returns the stored refresh token.
+	Load() (string, error)
+	// Save replaces the stored refresh token.
+	Save(refreshToken string) error
+}
+
+var errNoRefreshToken = errors.New("aliyun: no refresh token stored")
+
+// FileStore stores the refresh token in the file Path.
+type FileStore struct {
+	Path string
+}
+
+// Load returns the content of the file, without surrounding white space.
+func (s FileStore) Load() (string, error) {
+	buf, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return "", err
+	}
+	refreshToken := strings.TrimSpace(string(buf))
+	if refreshToken == "" {
+		return "", &os.PathError{Op: "load", Path: s.Path, Err: errNoRefreshToken}
+	}
+	return refreshToken, nil
+}
+
+// Save atomically replaces the file: the token is written to a temporary
+// file in the same directory, readable by the owner only, which is then
+// renamed over the old one. A crash never leaves a truncated token behind.
+func (s FileStore) Save(refreshToken string) error {
+	return writeFileAtomic(s.Path, []byte(refreshToken))
+}
+
+// writeFileAtomic replaces the file name with data, readable by the owner
+// only, by renaming a temporary file written next to it.
+func writeFileAtomic(name string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// EnvStore reads the refresh token from the environment variable Name, or
+// EnvRefreshToken if Name is empty. Save only updates the variable of the
+// running process, so a rotated token does not survive a restart.
+type EnvStore struct {
+	Name string
+}
+
+func (s EnvStore) name() string {
+	if s.Name == "" {
+		return EnvRefreshToken
+	}
+	return s.Name
+}
+
+func (s EnvStore) Load() (string, error) {
+	refreshToken := strings.TrimSpace(os.Getenv(s.name()))
+	if refreshToken == "" {
+		return "", errNoRefreshToken
+	}
+	return refreshToken, nil
+}
+
+func (s EnvStore) Save(refreshToken string) error {
+	return os.Setenv(s.name(), refreshToken)
+}
+
+var errNotSaved = errors.New("aliyun: the refresh token was given on the command line; its replacement is not saved and a restart needs a new one")
+
+// StaticStore holds a refresh token given on the command line. Save
+// discards rotated tokens, and reports that it did.
+type StaticStore string
+
+func (s StaticStore) Load() (string, error) {
+	if s == "" {
+		return "", errNoRefreshToken
+	}
+	return string(s), nil
+}
+
+func (s StaticStore) Save(refreshToken string) error { return errNotSaved }
+
+// SeededStore starts from the refresh token Seed, given on the command line,
+// and saves rotated tokens to the file Path. Once the file exists it takes
+// precedence over Seed, so that a restart with the same Seed does not try a
+// spent token.
+type SeededStore struct {
+	Seed string
+	Path string
+}
+
+func (s SeededStore) Load() (string, error) {
+	refreshToken, err := FileStore{Path: s.Path}.Load()
+	if errors.Is(err, os.ErrNotExist) {
+		return StaticStore(s.Seed).Load()
+	}
+	return refreshToken, err
+}
+
+func (s SeededStore) Save(refreshToken string) error {
+	return FileStore{Path: s.Path}.Save(refreshToken)
+}
+
+// ReadOnly returns a TokenStore that loads from s but never saves to it.
+func ReadOnly(s TokenStore) TokenStore {
+	return readOnlyStore{s}
+}
+
+type readOnlyStore struct {
+	TokenStore
+}
+
+func (readOnlyStore) Save(refreshToken string) error { return nil }
@@ -0,0 +1,176 @@
+package aliyun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-aliyun-webdav/aliyun/model"
+	"sync"
+	"time"
+)
+
+// A TokenSource supplies the access token and drive a Client acts with.
+type TokenSource interface {
+	// Token returns the current access token and the drive it belongs to.
+	Token() (token, driveId string, err error)
+}
+
+// StaticToken is a TokenSource that always returns the same access token
+// for the drive DriveId.
+type StaticToken struct {
+	AccessToken string
+	DriveId     string
+}
+
+func (t StaticToken) Token() (string, string, error) {
+	return t.AccessToken, t.DriveId, nil
+}
+
+var errNoToken = errors.New("aliyun: no access token")
+
+// A TokenManager is a TokenSource that keeps an access token fresh by
+// exchanging its refresh token before the access token expires.
+//
+// Concurrent use is permitted. At most one refresh runs at a time; callers
+// arriving while it runs wait for its result instead of starting another.
+// A failed refresh keeps the last good token.
+type TokenManager struct {
+	// Margin is how long before expiry the token is refreshed. If zero,
+	// five minutes are used.
+	Margin time.Duration
+	// RetryDelay is how long Run waits after a failed refresh before it
+	// tries again. If zero, one minute is used.
+	RetryDelay time.Duration
+	// Store, if not nil, receives every rotated refresh token.
+	Store TokenStore
+
+	mu     sync.Mutex
+	config model.Config
+	flight *refreshFlight
+}
+
+// refreshFlight is a refresh in progress. done is closed once err is set.
+type refreshFlight struct {
+	done chan struct{}
+	err  error
+}
+
+// NewTokenManager returns a TokenManager starting out with the tokens in
+// config.
+func NewTokenManager(config model.Config) *TokenManager {
+	return &TokenManager{config: config}
+}
+
+// LoadTokenManager returns a TokenManager for the refresh token held by
+// store. The token is refreshed right away, which rotates it, so the new
+// refresh token is saved back to store before LoadTokenManager returns.
+func LoadTokenManager(store TokenStore) (*TokenManager, error) {
+	refreshToken, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	m := NewTokenManager(model.Config{RefreshToken: refreshToken})
+	m.Store = store
+	if err := m.Refresh(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Config returns the tokens currently held.
+func (m *TokenManager) Config() model.Config {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.config
+}
+
+// Token returns the current access token. If it has already expired, for
+// instance because Run is not running, it is refreshed first; should that
+// fail, the expired token is returned and the API has the last word.
+func (m *TokenManager) Token() (string, string, error) {
+	config := m.Config()
+	if config.ExpireTime <= time.Now().Unix() {
+		if err := m.Refresh(); err != nil {
+			fmt.Println("刷新token失败,失败信息", err)
+		}
+		config = m.Config()
+	}
+	if config.Token == "" {
+		return "", "", errNoToken
+	}
+	return config.Token, config.DriveId, nil
+}
+
+// Refresh exchanges the refresh token for a new access token. If a refresh
+// is already running, Refresh waits for it and returns its result.
+func (m *TokenManager) Refresh() error {
+	m.mu.Lock()
+	if f := m.flight; f != nil {
+		m.mu.Unlock()
+		<-f.done
+		return f.err
+	}
+	f := &refreshFlight{done: make(chan struct{})}
+	m.flight = f
+	refreshToken := m.config.RefreshToken
+	m.mu.Unlock()
+
+	refreshResult, err := RefreshToken(refreshToken)
+	if err == nil && m.Store != nil && refreshResult.RefreshToken != refreshToken {
+		// The old refresh token is spent; losing the new one would
+		// lock us out after a restart, so report a failed save loudly
+		// but keep using the tokens we got.
+		if serr := m.Store.Save(refreshResult.RefreshToken); serr != nil {
+			fmt.Println("更新token文件失败,失败信息", serr)
+		}
+	}
+
+	m.mu.Lock()
+	if err == nil {
+		m.config = model.Config{
+			RefreshToken: refreshResult.RefreshToken,
+			Token:        refreshResult.AccessToken,
+			DriveId:      refreshResult.DefaultDriveId,
+			ExpireTime:   time.Now().Unix() + refreshResult.ExpiresIn,
+		}
+	}
+	m.flight = nil
+	m.mu.Unlock()
+
+	f.err = err
+	close(f.done)
+	return err
+}
+
+// Run refreshes the token in the background shortly before it expires,
+// until ctx is done.
+func (m *TokenManager) Run(ctx context.Context) {
+	margin := m.Margin
+	if margin <= 0 {
+		margin = 5 * time.Minute
+	}
+	retryDelay := m.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = time.Minute
+	}
+
+	var wait time.Duration
+	for {
+		if wait <= 0 {
+			expires := time.Unix(m.Config().ExpireTime, 0)
+			wait = time.Until(expires.Add(-margin))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		wait = 0
+		if err := m.Refresh(); err != nil {
+			fmt.Println("刷新token失败,失败信息", err)
+			wait = retryDelay
+		}
+	}
+}
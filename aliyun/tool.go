@@ -1,58 +1,301 @@
 package aliyun
 
 import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-//处理内容
-func ContentHandle(r *http.Request, token string, driveId string, parentId string, fileName string) {
-	//需要判断参数里面的有效期
-	//默认截取长度10485760
-	//const DEFAULT int64 = 10485760
-	const DEFAULT int64 = 10485760
-	var count float64 = 1
-	var total int64 = 0
-	byteSize := DEFAULT
+// DefaultPartSize is the part size used when Client.PartSize is zero.
+const DefaultPartSize int64 = 10 << 20
 
+// maxParts is the most parts one upload may consist of. Files too large
+// for that many parts of the configured size get larger parts.
+const maxParts = 10000
+
+// partBuffers holds *[]byte buffers for parts read from unseekable bodies,
+// so that concurrent uploads share a bounded set of buffers instead of
+// allocating one per part.
+var partBuffers sync.Pool
+
+func getPartBuffer(size int64) []byte {
+	if v, ok := partBuffers.Get().(*[]byte); ok && int64(cap(*v)) >= size {
+		return (*v)[:size]
+	}
+	return make([]byte, size)
+}
+
+func putPartBuffer(buf []byte) {
+	partBuffers.Put(&buf)
+}
+
+// partSize returns the size of the parts a file of size bytes is split into.
+func (c *Client) partSize(size int64) int64 {
+	partSize := c.PartSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	if min := (size + maxParts - 1) / maxParts; partSize < min {
+		partSize = min
+	}
+	return partSize
+}
+
+// ContentHandle uploads the size bytes read from r as the file fileName in
+// the folder parentId, one part at a time. If r is an io.ReaderAt, such as
+// a spooled *os.File, every part is streamed straight from it. Otherwise a
+// part is read through an io.LimitReader into a pooled buffer first, so that
+// a failed PUT can be retried. With RapidUpload set, seekable content is
+// hashed first and not uploaded at all if the drive already holds it.
+// replaces is the ID of the file fileName currently names, if any; it is
+// never removed should the upload fail.
+func (c *Client) ContentHandle(r io.Reader, size int64, parentId string, fileName string, replaces string) error {
+	return c.contentHandle(r, size, parentId, fileName, replaces, "")
+}
+
+// contentHandle is ContentHandle for content spooled to the file spooled,
+// if not empty, which r reads from. The journal records it, so that Recover
+// can resume the upload from it after a restart; Recover removes it then.
+func (c *Client) contentHandle(r io.Reader, size int64, parentId string, fileName string, replaces string, spooled string) error {
 	if len(parentId) == 0 {
 		parentId = "root"
 	}
-	if r.ContentLength > 0 {
-		count = math.Ceil(float64(r.ContentLength) / float64(DEFAULT))
-	} else {
-		//dataTemp, _ := io.ReadAll(r.Body)
-		//r.ContentLength = int64(len(dataTemp))
-		return
-	}
-	uploadUrl, uploadId, fileId := UpdateFileFile(token, driveId, fileName, parentId, strconv.FormatInt(r.ContentLength, 10), int(count))
-	if len(uploadUrl) == 0 {
-		return
-	}
-	for i := 0; i < int(count); i++ {
-		if r.ContentLength-total > DEFAULT {
-			byteSize = DEFAULT
-		} else {
-			byteSize = r.ContentLength - total
-		}
-		dataByte := make([]byte, byteSize)
-		n, err := io.ReadFull(r.Body, dataByte)
-		//n, err := r.Body.Read(dataByte)
+	if size < 0 {
+		return fmt.Errorf("aliyun: cannot upload %s of unknown size %d", fileName, size)
+	}
+	// An empty file has no parts; it is created and completed right away.
+	partSize := c.partSize(size)
+	count := int((size + partSize - 1) / partSize)
+	var contentHash, proofCode string
+	if ra, ok := r.(io.ReaderAt); ok && c.RapidUpload && size > 0 {
+		token, _, err := c.credentials()
+		if err != nil {
+			return err
+		}
+		contentHash, proofCode, err = contentProof(ra, size, token)
 		if err != nil {
-			fmt.Println("获取字节内容出错", err)
+			return err
 		}
-		total += int64(n)
-		//	fmt.Println("对比长度", total)
-		//	fmt.Println("提交数据的长度", len(dataByte))
+	}
+	upload, err := c.UpdateFileFile(fileName, parentId, size, count, contentHash, proofCode)
+	if err != nil {
+		return err
+	}
+	if upload.RapidUpload {
+		return nil
+	}
+
+	st := newUploadState(upload, parentId, fileName, size, partSize)
+	st.Replaces = replaces
+	st.Spool = spooled
+	if err := c.Journal.save(st); err != nil {
+		fmt.Println("保存上传记录失败,失败信息", err)
+	}
+	return c.finishUpload(st, r)
+}
 
-		//	u, _ := url.Parse(uploadUrl[i].Str)
-		//	params := u.Query()
-		//	fmt.Println(params.Get("x-oss-expires"))
-		UploadFile(uploadUrl[i].Str, token, dataByte)
+// finishUpload uploads the parts of st not done yet, reading them from r,
+// and completes the upload. Unless r is an io.ReaderAt, it must be
+// positioned at the first part not done. Should any step fail, the partial
+// file is removed again, unless it is a file the upload overwrites; either
+// way st is dropped from the journal.
+func (c *Client) finishUpload(st *uploadState, r io.Reader) error {
+	err := c.uploadParts(st, r)
+	if err == nil {
+		_, err = c.UploadFileComplete(st.UploadId, st.FileId)
 	}
+	if err != nil {
+		c.abortUpload(st)
+		return err
+	}
+	c.Journal.remove(st)
+	return nil
+}
 
-	UploadFileComplete(token, driveId, uploadId, fileId, parentId)
+// urlExpiryMargin is how long before its expiry a part URL is replaced, so
+// that it does not run out while the part is being sent.
+const urlExpiryMargin = 5 * time.Minute
+
+// partRetries is how often a part is sent again after its upload failed,
+// on top of the retries of package net for transient HTTP failures.
+const partRetries = 2
+
+// uploadParts sends the parts of st not done yet. Parts of an io.ReaderAt
+// are sent by up to UploadWorkers goroutines at once; other readers can
+// only be consumed in order, one part at a time. It returns the first
+// error, once every part under way has finished.
+func (c *Client) uploadParts(st *uploadState, r io.Reader) error {
+	var pending []int
+	for i, p := range st.Parts {
+		if !p.Done {
+			pending = append(pending, i)
+		}
+	}
+
+	ra, ok := r.(io.ReaderAt)
+	if !ok {
+		buf := getPartBuffer(st.PartSize)
+		defer putPartBuffer(buf)
+		for _, i := range pending {
+			off, n := st.section(i)
+			m, err := io.ReadFull(io.LimitReader(r, n), buf[:n])
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return fmt.Errorf("aliyun: upload of %s ended after %d of %d bytes", st.FileName, off+int64(m), st.Size)
+			}
+			if err != nil {
+				return err
+			}
+			if err := c.uploadPart(st, i, bytes.NewReader(buf[:n]), 0, n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	workers := c.UploadWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(pending) {
+		workers = len(pending)
+	}
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		next     = make(chan int)
+		stop     = make(chan struct{})
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				off, n := st.section(i)
+				if err := c.uploadPart(st, i, ra, off, n); err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(stop)
+					})
+				}
+			}
+		}()
+	}
+feed:
+	for _, i := range pending {
+		select {
+		case next <- i:
+		case <-stop:
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	return firstErr
+}
+
+// uploadPart sends the n bytes of body at off as part i of st. The part's
+// URL is replaced first if it is about to expire, and whenever the storage
+// servers reject it with 403, which they do once it has expired by their
+// clock.
+func (c *Client) uploadPart(st *uploadState, i int, body io.ReaderAt, off, n int64) error {
+	var err error
+	for attempt := 0; attempt <= partRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		url, expires := st.partUrl(i)
+		var apiErr *APIError
+		forbidden := errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden
+		if forbidden || expires != 0 && time.Now().Add(urlExpiryMargin).Unix() >= expires {
+			if err = c.refreshPartUrls(st, i, url); err != nil {
+				continue
+			}
+			url, _ = st.partUrl(i)
+		}
+		if err = c.UploadFile(url, body, off, n); err == nil {
+			st.setDone(i)
+			if err := c.Journal.save(st); err != nil {
+				fmt.Println("保存上传记录失败,失败信息", err)
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+// refreshPartUrls replaces the upload URLs of the parts of st not done yet,
+// unless another part has done so since the URL stale of part i was read.
+func (c *Client) refreshPartUrls(st *uploadState, i int, stale string) error {
+	st.refreshMu.Lock()
+	defer st.refreshMu.Unlock()
+	if url, _ := st.partUrl(i); url != stale {
+		return nil
+	}
+
+	st.mu.Lock()
+	var partNumbers []int
+	for _, p := range st.Parts {
+		if !p.Done {
+			partNumbers = append(partNumbers, p.PartNumber)
+		}
+	}
+	st.mu.Unlock()
+	infos, err := c.GetUploadUrl(st.FileId, st.UploadId, partNumbers)
+	if err != nil {
+		return err
+	}
+	urls := make(map[int]string, len(infos))
+	for _, info := range infos {
+		urls[info.PartNumber] = info.UploadUrl
+	}
+	st.mu.Lock()
+	for j := range st.Parts {
+		p := &st.Parts[j]
+		if u, ok := urls[p.PartNumber]; ok {
+			p.UploadUrl, p.Expires = u, urlExpiry(u)
+		}
+	}
+	st.mu.Unlock()
+	if err := c.Journal.save(st); err != nil {
+		fmt.Println("保存上传记录失败,失败信息", err)
+	}
+	return nil
+}
+
+// contentProof returns the SHA1 of the size bytes of r, in upper-case hex,
+// and the proof code the drive asks for to show that the content is really
+// at hand: the base64 of up to 8 bytes at an offset derived from the MD5 of
+// the access token.
+func contentProof(r io.ReaderAt, size int64, token string) (contentHash, proofCode string, err error) {
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return "", "", err
+	}
+
+	sum := md5.Sum([]byte(token))
+	n, err := strconv.ParseUint(hex.EncodeToString(sum[:])[:16], 16, 64)
+	if err != nil {
+		return "", "", err
+	}
+	off := int64(n % uint64(size))
+	end := off + 8
+	if end > size {
+		end = size
+	}
+	buf := make([]byte, end-off)
+	if _, err := r.ReadAt(buf, off); err != nil && err != io.EOF {
+		return "", "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil))), base64.StdEncoding.EncodeToString(buf), nil
 }
@@ -0,0 +1,259 @@
+package aliyun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-aliyun-webdav/aliyun/model"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An UploadJournal records the uploads in progress in the directory Dir, so
+// that uploads cut short by a crash or restart can be finished or cleaned
+// up by Recover. Bodies spooled for upload are kept in Dir as well.
+type UploadJournal struct {
+	Dir string
+	// MaxAge is how long an interrupted upload may be resumed. Older ones
+	// are aborted. If zero, 24 hours are used.
+	MaxAge time.Duration
+}
+
+// uploadState is the journal entry of one upload. Parts of it may be
+// uploaded concurrently; mu guards Parts once the upload has begun.
+type uploadState struct {
+	mu        sync.Mutex
+	refreshMu sync.Mutex // held while part URLs are replaced
+
+	UploadId     string       `json:"upload_id"`
+	FileId       string       `json:"file_id"`
+	ParentFileId string       `json:"parent_file_id"`
+	FileName     string       `json:"file_name"`
+	Size         int64        `json:"size"`
+	PartSize     int64        `json:"part_size"`
+	Parts        []uploadPart `json:"parts"`
+	// Replaces is the ID of the file the upload overwrites, if any. The
+	// drive keeps that ID for the new content, so it must not be removed
+	// when the upload is aborted.
+	Replaces string `json:"replaces,omitempty"`
+	// Spool names the local file the content is read from. It is empty
+	// when the content came straight from a request, which cannot be
+	// resumed.
+	Spool   string    `json:"spool,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+type uploadPart struct {
+	PartNumber int    `json:"part_number"`
+	UploadUrl  string `json:"upload_url"`
+	// Expires is when UploadUrl stops working, in Unix seconds, or 0 if
+	// unknown.
+	Expires int64 `json:"expires"`
+	Done    bool  `json:"done"`
+}
+
+const journalExt = ".upload.json"
+
+// spoolPrefix starts the names of the files request bodies are spooled to.
+const spoolPrefix = "aliyun-upload-"
+
+func newUploadState(upload model.UploadModel, parentFileId, fileName string, size, partSize int64) *uploadState {
+	st := &uploadState{
+		UploadId:     upload.UploadId,
+		FileId:       upload.FileId,
+		ParentFileId: parentFileId,
+		FileName:     fileName,
+		Size:         size,
+		PartSize:     partSize,
+		Created:      time.Now(),
+	}
+	for _, p := range upload.PartInfoList {
+		st.Parts = append(st.Parts, uploadPart{
+			PartNumber: p.PartNumber,
+			UploadUrl:  p.UploadUrl,
+			Expires:    urlExpiry(p.UploadUrl),
+		})
+	}
+	return st
+}
+
+// partUrl returns the upload URL of the part i and when it expires.
+func (st *uploadState) partUrl(i int) (string, int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.Parts[i].UploadUrl, st.Parts[i].Expires
+}
+
+func (st *uploadState) setDone(i int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Parts[i].Done = true
+}
+
+// section returns the offset and length of the part i.
+func (st *uploadState) section(i int) (off, n int64) {
+	off = int64(i) * st.PartSize
+	n = st.Size - off
+	if n > st.PartSize {
+		n = st.PartSize
+	}
+	return off, n
+}
+
+// urlExpiry returns the x-oss-expires time of the signed URL rawurl, in
+// Unix seconds, or 0 if it has none.
+func urlExpiry(rawurl string) int64 {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return 0
+	}
+	expires, _ := strconv.ParseInt(u.Query().Get("x-oss-expires"), 10, 64)
+	return expires
+}
+
+func (j *UploadJournal) entry(st *uploadState) string {
+	return filepath.Join(j.Dir, st.UploadId+journalExt)
+}
+
+// save records st. A nil journal records nothing.
+func (j *UploadJournal) save(st *uploadState) error {
+	if j == nil {
+		return nil
+	}
+	// Hold st for the write as well, so that a snapshot older than one
+	// already written cannot replace it.
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(j.entry(st), data)
+}
+
+// remove forgets st. A nil journal does nothing.
+func (j *UploadJournal) remove(st *uploadState) {
+	if j == nil {
+		return
+	}
+	if err := os.Remove(j.entry(st)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Println("删除上传记录失败,失败信息", err)
+	}
+}
+
+// load returns the uploads recorded in the journal.
+func (j *UploadJournal) load() ([]*uploadState, error) {
+	infos, err := ioutil.ReadDir(j.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var states []*uploadState
+	for _, fi := range infos {
+		if !strings.HasSuffix(fi.Name(), journalExt) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(j.Dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		st := new(uploadState)
+		if err := json.Unmarshal(data, st); err != nil || st.UploadId == "" {
+			fmt.Println("上传记录已损坏,已删除", fi.Name())
+			os.Remove(filepath.Join(j.Dir, fi.Name()))
+			continue
+		}
+		states = append(states, st)
+	}
+	return states, nil
+}
+
+// resumable reports why st cannot be resumed, or nil if it can.
+func (j *UploadJournal) resumable(st *uploadState) error {
+	maxAge := j.MaxAge
+	if maxAge <= 0 {
+		maxAge = 24 * time.Hour
+	}
+	if time.Since(st.Created) > maxAge {
+		return errors.New("upload is too old")
+	}
+	if st.Spool == "" {
+		return errors.New("content was not spooled")
+	}
+	_, err := os.Stat(st.Spool)
+	return err
+}
+
+// Recover finishes the uploads recorded in the journal of c that were cut
+// short, if their content is still spooled locally, and aborts the others
+// so that no partial files linger on the drive. It is meant to run once at
+// startup; uploads begun after it started are left alone.
+func (c *Client) Recover() error {
+	j := c.Journal
+	if j == nil {
+		return nil
+	}
+	start := time.Now()
+	states, err := j.load()
+	if err != nil {
+		return err
+	}
+	for _, st := range states {
+		if !st.Created.Before(start) {
+			continue
+		}
+		if err := j.resumable(st); err != nil {
+			fmt.Println("放弃未完成的上传", st.FileName, err)
+			c.abortUpload(st)
+		} else if err := c.resumeUpload(st); err != nil {
+			fmt.Println("恢复上传失败", st.FileName, err)
+		} else {
+			fmt.Println("恢复上传成功", st.FileName)
+		}
+		if st.Spool != "" {
+			os.Remove(st.Spool)
+		}
+	}
+
+	// Bodies spooled before the start by uploads that never got to create
+	// their file are garbage. Other files in Dir are none of our business.
+	infos, err := ioutil.ReadDir(j.Dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		if fi.Mode().IsRegular() && strings.HasPrefix(fi.Name(), spoolPrefix) && fi.ModTime().Before(start) {
+			os.Remove(filepath.Join(j.Dir, fi.Name()))
+		}
+	}
+	return nil
+}
+
+// resumeUpload uploads the parts of st not done yet from its spool and
+// completes the upload.
+func (c *Client) resumeUpload(st *uploadState) error {
+	f, err := os.Open(st.Spool)
+	if err != nil {
+		c.abortUpload(st)
+		return err
+	}
+	defer f.Close()
+	return c.finishUpload(st, f)
+}
+
+// abortUpload removes the partial file of st from the drive and forgets st.
+// A file that existed before the upload is left alone; until the upload is
+// completed, it keeps its old content.
+func (c *Client) abortUpload(st *uploadState) {
+	if st.FileId != st.Replaces {
+		if err := c.RemoveTrash(st.FileId); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("清理未完成的上传失败,失败信息", st.FileName, err)
+		}
+	}
+	c.Journal.remove(st)
+}
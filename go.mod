@@ -1,9 +1,3 @@
 module go-aliyun-webdav
 
 go 1.16
-
-require (
-	github.com/patrickmn/go-cache v2.1.0+incompatible
-	github.com/tidwall/gjson v1.9.0
-	github.com/tidwall/pretty v1.2.0 // indirect
-)
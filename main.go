@@ -2,55 +2,119 @@ package main
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"go-aliyun-webdav/aliyun"
-	"go-aliyun-webdav/aliyun/cache"
-	"go-aliyun-webdav/aliyun/model"
+	"go-aliyun-webdav/aliyun/net"
 	"go-aliyun-webdav/webdav"
-	"reflect"
 
 	//"gorm.io/driver/sqlite"
 	//"gorm.io/gorm"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
-	"strings"
 	"time"
 )
 
-func init() {
-	cache.Init()
-}
-
 var Version = "v1.1.2"
 
 type Task struct {
 	Id string `json:"id"`
 }
 
+// tokenStore picks where the refresh token comes from: rt names a file
+// holding it or is the token itself; without rt it is read from the
+// environment.
+func tokenStore(rt string, readOnly bool) aliyun.TokenStore {
+	var store aliyun.TokenStore
+	switch {
+	case rt == "":
+		store = aliyun.EnvStore{}
+	case isFile(rt):
+		store = aliyun.FileStore{Path: rt}
+	case readOnly:
+		store = aliyun.StaticStore(rt)
+	default:
+		store = seededStore(rt)
+	}
+	if readOnly {
+		store = aliyun.ReadOnly(store)
+	}
+	return store
+}
+
+// seededStore keeps the tokens the refresh token rt is rotated to in a file
+// of the user's config directory named after rt, so that restarting with
+// the same rt goes on with the latest token.
+func seededStore(rt string) aliyun.TokenStore {
+	dir, err := os.UserConfigDir()
+	if err == nil {
+		dir = filepath.Join(dir, "aliyun-webdav")
+		err = os.MkdirAll(dir, 0700)
+	}
+	if err != nil {
+		fmt.Println("无法保存刷新后的refreshToken,重启时需要新的refreshToken,失败信息", err)
+		return aliyun.StaticStore(rt)
+	}
+	sum := sha1.Sum([]byte(rt))
+	path := filepath.Join(dir, "refreshToken-"+hex.EncodeToString(sum[:4]))
+	fmt.Println("刷新后的refreshToken保存在", path)
+	return aliyun.SeededStore{Seed: rt, Path: path}
+}
+
+func isFile(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.Mode().IsRegular()
+}
+
 func main() {
 	//GetDb()
 	var port *string
-	var path *string
 	var refreshToken *string
 	var user *string
 	var pwd *string
 	var versin *bool
 	var log *bool
 	var check *string
+	var timeout *time.Duration
+	var retry *int
+	var readOnly *bool
+	var partSize *int64
+	var rapid *bool
+	var uploadDir *string
+	var workers *int
+	var redirect *bool
+	var redirectUA *string
+	var permanentDelete *bool
+	var maxNodes *int
 
 	//
 	port = flag.String("port", "8085", "默认8085")
-	path = flag.String("path", "./", "")
+	// -path 曾指定本地目录,现在文件都在阿里云盘上;保留该参数以免旧的启动脚本报错。
+	flag.String("path", "./", "已废弃,不再使用")
 	user = flag.String("user", "admin", "用户名")
 	pwd = flag.String("pwd", "123456", "密码")
 	versin = flag.Bool("V", false, "显示版本")
 	log = flag.Bool("v", false, "是否显示日志(默认不显示)")
 	//log = flag.Bool("v", true, "是否显示日志(默认不显示)")
-	refreshToken = flag.String("rt", "", "refresh_token")
+	refreshToken = flag.String("rt", "", "refresh_token,或保存refresh_token的文件路径(未指定时读取环境变量"+aliyun.EnvRefreshToken+")")
+	readOnly = flag.Bool("rtro", false, "不回写刷新后的refresh_token")
+	partSize = flag.Int64("part", aliyun.DefaultPartSize>>20, "上传分片大小(MB)")
+	rapid = flag.Bool("rapid", false, "启用秒传(上传前先缓存到本地计算sha1)")
+	workers = flag.Int("workers", 1, "本地缓存文件上传时并发上传的分片数")
+	redirect = flag.Bool("redirect", false, "下载时重定向到阿里云盘的下载地址,不经过本机转发(客户端需能发送Referer)")
+	redirectUA = flag.String("redirectua", "", "只对User-Agent匹配该正则的客户端重定向(默认全部)")
+	uploadDir = flag.String("uploaddir", "", "上传缓存目录,记录未完成的上传以便重启后续传")
+	permanentDelete = flag.Bool("rm", false, "删除时彻底删除,不放入回收站")
+	maxNodes = flag.Int("maxnodes", webdav.DefaultMaxPropfindNodes, "Depth为infinity的PROPFIND最多列出的文件数,负数表示不支持")
 
 	check = flag.String("crt", "", "检查refreshToken是否过期")
+	timeout = flag.Duration("timeout", net.DefaultOptions.RequestTimeout, "接口请求超时时间")
+	retry = flag.Int("retry", net.DefaultOptions.MaxRetries, "接口请求失败重试次数")
 
 	flag.Parse()
 	if *versin {
@@ -58,24 +122,28 @@ func main() {
 		return
 	}
 
-	if len(*check) > 0 {
-		refreshResult := aliyun.RefreshToken(*check)
-		if reflect.DeepEqual(refreshResult, model.RefreshTokenModel{}) {
+	options := net.DefaultOptions
+	options.RequestTimeout = *timeout
+	options.MaxRetries = *retry
+	net.Configure(options)
 
-			fmt.Println("refreshToken已过期")
+	if len(*check) > 0 {
+		_, err := aliyun.LoadTokenManager(tokenStore(*check, *readOnly))
+		if err != nil {
+			fmt.Println("refreshToken已过期", err)
 		} else {
 			fmt.Println("refreshToken可以使用")
 		}
 		return
 	}
 
-	if len(*refreshToken) == 0 {
-		fmt.Println("rt为必填项,请输入refreshToken")
-		return
-	}
 	if len(os.Args) > 2 && os.Args[1] == "rt" {
 		*refreshToken = os.Args[2]
 	}
+	if len(*refreshToken) == 0 && len(os.Getenv(aliyun.EnvRefreshToken)) == 0 {
+		fmt.Println("rt为必填项,请输入refreshToken")
+		return
+	}
 	var address string
 	if runtime.GOOS == "windows" {
 		address = ":" + *port
@@ -83,20 +151,49 @@ func main() {
 
 		address = "0.0.0.0:" + *port
 	}
-	refreshResult := aliyun.RefreshToken(*refreshToken)
-
-	config := model.Config{
-		RefreshToken: refreshResult.RefreshToken,
-		Token:        refreshResult.AccessToken,
-		DriveId:      refreshResult.DefaultDriveId,
-		ExpireTime:   time.Now().Unix() + refreshResult.ExpiresIn,
+	tokens, err := aliyun.LoadTokenManager(tokenStore(*refreshToken, *readOnly))
+	if err != nil {
+		fmt.Println("刷新token失败,失败信息", err)
+		return
+	}
+	go tokens.Run(context.Background())
+
+	drive := aliyun.NewFileSystem(tokens)
+	drive.Client().PartSize = *partSize << 20
+	drive.Client().RapidUpload = *rapid
+	drive.Client().UploadWorkers = *workers
+	drive.Client().PermanentDelete = *permanentDelete
+	if len(*uploadDir) > 0 {
+		// Keep to a subdirectory of our own, so that Recover cannot touch
+		// anything else in the directory given.
+		dir := filepath.Join(*uploadDir, "aliyun-webdav-upload")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			fmt.Println("创建上传缓存目录失败,失败信息", err)
+			return
+		}
+		drive.Client().Journal = &aliyun.UploadJournal{Dir: dir}
+		go func() {
+			if err := drive.Client().Recover(); err != nil {
+				fmt.Println("恢复上传失败,失败信息", err)
+			}
+		}()
 	}
 
 	fs := &webdav.Handler{
-		Prefix:     "/",
-		FileSystem: webdav.Dir(*path),
-		LockSystem: webdav.NewMemLS(),
-		Config:     config,
+		Prefix:           "/",
+		FileSystem:       drive,
+		LockSystem:       webdav.NewMemLS(),
+		MaxPropfindNodes: *maxNodes,
+	}
+	if *redirect {
+		ua, err := regexp.Compile(*redirectUA)
+		if err != nil {
+			fmt.Println("redirectua不是有效的正则表达式", err)
+			return
+		}
+		fs.Redirect = func(r *http.Request) bool {
+			return ua.MatchString(r.UserAgent())
+		}
 	}
 
 	//fmt.p
@@ -123,16 +220,6 @@ func main() {
 
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
-		if req.Method == "GET" && strings.HasPrefix(req.URL.Path, fs.Prefix) {
-			info, err := fs.FileSystem.Stat(context.TODO(), strings.TrimPrefix(req.URL.Path, fs.Prefix))
-			if err == nil && info.IsDir() {
-				req.Method = "PROPFIND"
-
-				if req.Header.Get("Depth") == "" {
-					req.Header.Add("Depth", "1")
-				}
-			}
-		}
 		if *log {
 			fmt.Println(req.URL)
 			fmt.Println(req.Method)
@@ -0,0 +1,184 @@
+package webdav
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A dirEntry is one item of a directory listing served to browsers.
+type dirEntry struct {
+	Name        string    `json:"name"`
+	Href        string    `json:"href"`
+	IsDir       bool      `json:"is_dir"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	ContentType string    `json:"content_type,omitempty"`
+	Thumbnail   string    `json:"thumbnail,omitempty"`
+}
+
+// A crumb is one step of the breadcrumb above a directory listing.
+type crumb struct {
+	Name string
+	Href string
+}
+
+// dirSorts holds how a listing may be sorted, by the sort query parameter.
+var dirSorts = map[string]func(a, b *dirEntry) bool{
+	"name":  func(a, b *dirEntry) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) },
+	"size":  func(a, b *dirEntry) bool { return a.Size < b.Size },
+	"mtime": func(a, b *dirEntry) bool { return a.ModTime.Before(b.ModTime) },
+}
+
+// serveDirList answers a GET of the directory reqPath, whose children are
+// read from f, with an HTML index page, or with JSON if r accepts
+// application/json. The entries are sorted by the sort query parameter
+// (name, size or mtime) in the order given by the order parameter (asc or
+// desc); folders always come first.
+func (h *Handler) serveDirList(w http.ResponseWriter, r *http.Request, reqPath string, f File) (int, error) {
+	children, err := f.Readdir(-1)
+	if err != nil {
+		return errorStatus(err, http.StatusInternalServerError), err
+	}
+	base := path.Join("/", h.Prefix, reqPath)
+	entries := make([]*dirEntry, 0, len(children))
+	for _, fi := range children {
+		item := fileModel(fi)
+		e := &dirEntry{
+			Name:        fi.Name(),
+			Href:        escapePath(path.Join(base, fi.Name())),
+			IsDir:       fi.IsDir(),
+			Size:        fi.Size(),
+			ModTime:     fi.ModTime(),
+			ContentType: contentType(item),
+			Thumbnail:   item.Thumbnail,
+		}
+		if e.IsDir {
+			e.Href += "/"
+			e.Size = 0
+			e.ContentType = ""
+		}
+		entries = append(entries, e)
+	}
+
+	q := r.URL.Query()
+	key, order := q.Get("sort"), q.Get("order")
+	less, ok := dirSorts[key]
+	if !ok {
+		key, less = "name", dirSorts["name"]
+	}
+	if order != "desc" {
+		order = "asc"
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if order == "desc" {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+
+	crumbs := []crumb{{Name: "/", Href: escapePath(path.Join("/", h.Prefix)) + "/"}}
+	href := path.Join("/", h.Prefix)
+	for _, name := range strings.Split(strings.Trim(reqPath, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		href = path.Join(href, name)
+		crumbs = append(crumbs, crumb{Name: name, Href: escapePath(href) + "/"})
+	}
+	// Each column header sorts by its column, toggling the order if the
+	// listing is already sorted by it.
+	sortLinks := make(map[string]string, len(dirSorts))
+	for k := range dirSorts {
+		o := "asc"
+		if k == key && order == "asc" {
+			o = "desc"
+		}
+		sortLinks[k] = "?sort=" + k + "&order=" + o
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = dirListTemplate.Execute(w, struct {
+		Path    string
+		Crumbs  []crumb
+		Entries []*dirEntry
+		Sort    map[string]string
+	}{
+		Path:    path.Join("/", reqPath),
+		Crumbs:  crumbs,
+		Entries: entries,
+		Sort:    sortLinks,
+	})
+	return 0, err
+}
+
+// escapePath escapes p for use as the path of a URL.
+func escapePath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// formatSize returns n as a human readable size.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64) + " " + string("KMGTPE"[exp]) + "iB"
+}
+
+var dirListTemplate = template.Must(template.New("dirlist").Funcs(template.FuncMap{
+	"size": formatSize,
+	"time": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: .3em .6em; text-align: left; border-bottom: 1px solid #eee; }
+td.size { text-align: right; white-space: nowrap; }
+img.thumb { max-width: 64px; max-height: 48px; vertical-align: middle; }
+a { text-decoration: none; }
+</style>
+</head>
+<body>
+<h1>{{range $i, $c := .Crumbs}}{{if $i}} / {{end}}<a href="{{$c.Href}}">{{$c.Name}}</a>{{end}}</h1>
+<table>
+<tr><th></th><th><a href="{{index .Sort "name"}}">名称</a></th><th><a href="{{index .Sort "size"}}">大小</a></th><th><a href="{{index .Sort "mtime"}}">修改时间</a></th><th></th></tr>
+{{range .Entries}}<tr>
+<td>{{if .Thumbnail}}<img class="thumb" src="{{.Thumbnail}}" loading="lazy" alt="">{{else if .IsDir}}📁{{else}}📄{{end}}</td>
+<td><a href="{{.Href}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td>
+<td class="size">{{if not .IsDir}}{{size .Size}}{{end}}</td>
+<td>{{time .ModTime}}</td>
+<td>{{if not .IsDir}}<a href="{{.Href}}" download>下载</a>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
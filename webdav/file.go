@@ -8,9 +8,9 @@ import (
 	"context"
 	"encoding/xml"
 	"fmt"
-	"go-aliyun-webdav/aliyun"
 	"go-aliyun-webdav/aliyun/model"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path"
@@ -58,6 +58,112 @@ type File interface {
 	io.Writer
 }
 
+// A Putter is an optional interface for a FileSystem that can store a file
+// directly from a stream, instead of through OpenFile and Write.
+type Putter interface {
+	// Put stores the size bytes read from r as the file name, replacing
+	// any existing file of that name. A negative size means the length is
+	// not known up front, as with chunked request bodies; all of r is
+	// stored then.
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+}
+
+// A Copier is an optional interface for a FileSystem that can copy files
+// itself, such as on the server holding them, instead of through OpenFile.
+type Copier interface {
+	// Copy copies src to dst, which does not exist. A directory is copied
+	// with all its descendants if recursive is set, or else as an empty
+	// directory. If only some descendants could not be copied, Copy
+	// returns a *PartialError.
+	Copy(ctx context.Context, src, dst string, recursive bool) error
+}
+
+// A PartialError reports the members of a collection an operation failed
+// for, while it succeeded for the rest of the collection.
+type PartialError struct {
+	// Errs maps the path of each failed member to its error.
+	Errs map[string]error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("webdav: %d members of the collection failed", len(e.Errs))
+}
+
+// add records err as the error of name, merging in the members of err if
+// it is a *PartialError itself.
+func (e *PartialError) add(name string, err error) {
+	if e.Errs == nil {
+		e.Errs = make(map[string]error)
+	}
+	if pe, ok := err.(*PartialError); ok {
+		for k, v := range pe.Errs {
+			e.Errs[k] = v
+		}
+		return
+	}
+	e.Errs[name] = err
+}
+
+// A QuotaReporter is an optional interface for a FileSystem that knows how
+// much storage space it has.
+type QuotaReporter interface {
+	// Quota returns the available and used storage space, in bytes.
+	Quota(ctx context.Context) (available, used int64, err error)
+}
+
+// A Downloader is an optional interface for a File whose content is held by
+// a remote server. A Handler relays such content instead of reading it
+// through the File.
+type Downloader interface {
+	// Download requests the file's content, passing rangeStr and ifRange
+	// on as the Range and If-Range headers. The caller must close the
+	// response body.
+	Download(ctx context.Context, rangeStr, ifRange string) (*http.Response, error)
+}
+
+// A Redirector is an optional interface for a File whose content can be
+// downloaded by clients directly from a remote server. See Handler.Redirect.
+type Redirector interface {
+	// DownloadURL returns a URL the file's content can be downloaded from.
+	DownloadURL(ctx context.Context) (string, error)
+}
+
+// fileModel returns the drive item described by fi. FileInfos returned by a
+// drive-backed FileSystem carry their model.ListModel in Sys; for any other
+// FileSystem an equivalent item is made up from fi itself.
+func fileModel(fi os.FileInfo) model.ListModel {
+	if item, ok := fi.Sys().(model.ListModel); ok {
+		return item
+	}
+	item := model.ListModel{
+		Name:      fi.Name(),
+		Type:      "file",
+		Size:      fi.Size(),
+		CreatedAt: fi.ModTime(),
+		UpdatedAt: fi.ModTime(),
+	}
+	if fi.IsDir() {
+		item.Type = "folder"
+		item.Size = 0
+	} else {
+		item.ContentType = mime.TypeByExtension(path.Ext(fi.Name()))
+	}
+	return item
+}
+
+// contentType returns the media type of the file item. The drive fills in
+// mime_type more reliably than content_type; without either, the content is
+// declared to be arbitrary binary data.
+func contentType(item model.ListModel) string {
+	if item.MimeType != "" {
+		return item.MimeType
+	}
+	if item.ContentType != "" {
+		return item.ContentType
+	}
+	return "application/octet-stream"
+}
+
 // A Dir implements FileSystem using the native file system restricted to a
 // specific directory tree.
 //
@@ -611,10 +717,24 @@ func (f *memFile) Write(p []byte) (int, error) {
 //
 // See section 9.9.4 for when various HTTP status codes apply.
 func moveFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bool) (status int, err error) {
+	if _, err := fs.Stat(ctx, src); err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusNotFound, err
+		}
+		return errorStatus(err, http.StatusInternalServerError), err
+	}
+	if isDescendant(src, dst) {
+		return http.StatusForbidden, errDestinationInsideSource
+	}
+	// Overwriting an ancestor of the source would remove the source with
+	// it before it could be moved.
+	if isDescendant(dst, src) {
+		return http.StatusForbidden, errSourceInsideDestination
+	}
 	created := false
-	if _, err := fs.Stat(ctx, dst); err != nil && false {
+	if _, err := fs.Stat(ctx, dst); err != nil {
 		if !os.IsNotExist(err) {
-			return http.StatusForbidden, err
+			return errorStatus(err, http.StatusForbidden), err
 		}
 		created = true
 	} else if overwrite {
@@ -623,13 +743,17 @@ func moveFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 		// the server must perform a DELETE with "Depth: infinity" on the
 		// destination resource.
 		if err := fs.RemoveAll(ctx, dst); err != nil {
-			return http.StatusForbidden, err
+			return errorStatus(err, http.StatusForbidden), err
 		}
 	} else {
 		return http.StatusPreconditionFailed, os.ErrExist
 	}
 	if err := fs.Rename(ctx, src, dst); err != nil {
-		return http.StatusForbidden, err
+		if os.IsNotExist(err) {
+			// The source was there, so the destination's parent is not.
+			return http.StatusConflict, err
+		}
+		return errorStatus(err, http.StatusForbidden), err
 	}
 	if created {
 		return http.StatusCreated, nil
@@ -667,9 +791,6 @@ func copyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 	}
 	recursion++
 
-	// TODO: section 9.8.3 says that "Note that an infinite-depth COPY of /A/
-	// into /A/B/ could lead to infinite recursion if not handled correctly."
-
 	srcFile, err := fs.OpenFile(ctx, src, os.O_RDONLY, 0)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -687,6 +808,17 @@ func copyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 	}
 	srcPerm := srcStat.Mode() & os.ModePerm
 
+	// Section 9.8.3 says that "Note that an infinite-depth COPY of /A/
+	// into /A/B/ could lead to infinite recursion if not handled correctly."
+	if srcStat.IsDir() && depth == infiniteDepth && isDescendant(src, dst) {
+		return http.StatusForbidden, errDestinationInsideSource
+	}
+	// Overwriting an ancestor of the source would remove the source before
+	// it could be copied.
+	if isDescendant(dst, src) {
+		return http.StatusForbidden, errSourceInsideDestination
+	}
+
 	created := false
 	if _, err := fs.Stat(ctx, dst); err != nil {
 		if os.IsNotExist(err) {
@@ -703,7 +835,17 @@ func copyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 		}
 	}
 
-	if srcStat.IsDir() {
+	if c, ok := fs.(Copier); ok {
+		if err := c.Copy(ctx, src, dst, depth == infiniteDepth); err != nil {
+			if _, ok := err.(*PartialError); ok {
+				return StatusMulti, err
+			}
+			if os.IsNotExist(err) {
+				return http.StatusConflict, err
+			}
+			return errorStatus(err, http.StatusForbidden), err
+		}
+	} else if srcStat.IsDir() {
 		if err := fs.Mkdir(ctx, dst, srcPerm); err != nil {
 			return http.StatusForbidden, err
 		}
@@ -712,16 +854,20 @@ func copyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 			if err != nil {
 				return http.StatusForbidden, err
 			}
+			// Members that cannot be copied are reported in a
+			// Multi-Status response, after the others were copied.
+			pe := &PartialError{}
 			for _, c := range children {
 				name := c.Name()
 				s := path.Join(src, name)
 				d := path.Join(dst, name)
-				cStatus, cErr := copyFiles(ctx, fs, s, d, overwrite, depth, recursion)
-				if cErr != nil {
-					// TODO: MultiStatus.
-					return cStatus, cErr
+				if _, cErr := copyFiles(ctx, fs, s, d, overwrite, depth, recursion); cErr != nil {
+					pe.add(d, cErr)
 				}
 			}
+			if len(pe.Errs) > 0 {
+				return StatusMulti, pe
+			}
 		}
 
 	} else {
@@ -753,47 +899,75 @@ func copyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 	return http.StatusNoContent, nil
 }
 
-type WalkFunc func(parent model.ListModel, info model.FileListModel, err error) error
+// isDescendant reports whether name lies within the directory dir.
+func isDescendant(dir, name string) bool {
+	dir, name = slashClean(dir), slashClean(name)
+	if dir == "/" {
+		return name != "/"
+	}
+	return strings.HasPrefix(name, dir+"/")
+}
 
 // walkFS traverses filesystem fs starting at name up to depth levels.
 //
 // Allowed values for depth are 0, 1 or infiniteDepth. For each visited node,
 // walkFS calls walkFn. If a visited file system node is a directory and
 // walkFn returns filepath.SkipDir, walkFS will skip traversal of this node.
-func walkFS(ctx context.Context, fs FileSystem, depth int, parent model.ListModel, info model.FileListModel, walkFn WalkFunc, token, driver, userAgent string, cheng int) error {
-	// This implementation is based on Walk's code in the standard path/filepath package.
-	err := walkFn(parent, info, nil)
+//
+// Directories are listed breadth first from a queue, so that the members of
+// each directory are passed to walkFn as soon as it has been listed, and a
+// deep tree does not deepen the stack.
+func walkFS(ctx context.Context, fs FileSystem, depth int, name string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	err := walkFn(name, info, nil)
 	if err != nil {
-		fmt.Println(err)
+		if info.IsDir() && err == filepath.SkipDir {
+			return nil
+		}
+		return err
 	}
-	if depth == 1 {
-		depth = 0
+	if !info.IsDir() || depth == 0 {
+		return nil
 	}
-	// Read directory names.
 
-	for _, fileInfo := range info.Items {
-		//filename := path.Join(parent.Name, fileInfo.Name)
-		//fileInfo, err := fs.Stat(ctx, filename)
-		//fileList, err := aliyun.GetList(token, driver, fileInfo.FileId)
-		var fileList model.FileListModel
+	type dir struct {
+		name string
+		info os.FileInfo
+	}
+	queue := []dir{{name, info}}
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+
+		// Read directory names.
+		f, err := fs.OpenFile(ctx, d.name, os.O_RDONLY, 0)
 		if err != nil {
-			if err := walkFn(fileInfo, fileList, err); err != nil && err != filepath.SkipDir {
+			if err := walkFn(d.name, d.info, err); err != nil && err != filepath.SkipDir {
 				return err
 			}
-		} else {
-			cheng += 1
-			if fileInfo.Type == "folder" && !strings.Contains(userAgent, "RaiDrive") && cheng < 2 {
-				info, _ := aliyun.GetList(token, driver, fileInfo.FileId)
-				walkFS(ctx, fs, depth, fileInfo, info, walkFn, token, driver, userAgent, cheng)
-			} else {
-				err = walkFS(ctx, fs, depth, fileInfo, fileList, walkFn, token, driver, userAgent, cheng)
-				if err != nil {
-					if fileInfo.Type != "folder" || err != filepath.SkipDir {
-						return err
-					}
-				}
+			continue
+		}
+		fileInfos, err := f.Readdir(0)
+		f.Close()
+		if err != nil {
+			if err := walkFn(d.name, d.info, err); err != nil && err != filepath.SkipDir {
+				return err
 			}
+			continue
+		}
 
+		// The listing describes the members well enough; they are not
+		// looked up again one by one.
+		for _, fileInfo := range fileInfos {
+			filename := path.Join(d.name, fileInfo.Name())
+			if err := walkFn(filename, fileInfo, nil); err != nil {
+				if fileInfo.IsDir() && err == filepath.SkipDir {
+					continue
+				}
+				return err
+			}
+			if fileInfo.IsDir() && depth == infiniteDepth {
+				queue = append(queue, dir{filename, fileInfo})
+			}
 		}
 	}
 	return nil
@@ -49,23 +49,22 @@ type Propstat struct {
 	ResponseDescription string
 }
 
-// makePropstats returns a slice containing those of x and y whose Props slice
-// is non-empty. If both are empty, it returns a slice containing an otherwise
+// makePropstats returns a slice containing those of pstats whose Props slice
+// is non-empty. If all are empty, it returns a slice containing an otherwise
 // zero Propstat whose HTTP status code is 200 OK.
-func makePropstats(x, y Propstat) []Propstat {
-	pstats := make([]Propstat, 0, 2)
-	if len(x.Props) != 0 {
-		pstats = append(pstats, x)
-	}
-	if len(y.Props) != 0 {
-		pstats = append(pstats, y)
+func makePropstats(pstats ...Propstat) []Propstat {
+	nonEmpty := make([]Propstat, 0, len(pstats))
+	for _, pstat := range pstats {
+		if len(pstat.Props) != 0 {
+			nonEmpty = append(nonEmpty, pstat)
+		}
 	}
-	if len(pstats) == 0 {
-		pstats = append(pstats, Propstat{
+	if len(nonEmpty) == 0 {
+		nonEmpty = append(nonEmpty, Propstat{
 			Status: http.StatusOK,
 		})
 	}
-	return pstats
+	return nonEmpty
 }
 
 // DeadPropsHolder holds the dead properties of a resource.
@@ -104,6 +103,12 @@ var liveProps = map[xml.Name]struct {
 	findFn func(context.Context, FileSystem, LockSystem, model.ListModel) (string, error)
 	// dir is true if the property applies to directories.
 	dir bool
+	// dirOnly is true if the property applies to directories but not to
+	// files.
+	dirOnly bool
+	// noAllprop is true if the property is only returned when asked for
+	// by name, not for allprop.
+	noAllprop bool
 }{
 	{Space: "DAV:", Local: "resourcetype"}: {
 		findFn: findResourceType,
@@ -136,14 +141,21 @@ var liveProps = map[xml.Name]struct {
 		findFn: nil,
 		dir:    false,
 	},
-	// {Space: "DAV:", Local: "quota-available-bytes"}: {
-	// 	findFn: quota,
-	// 	dir:    true,
-	// },
-	// {Space: "DAV:", Local: "quota-used-bytes"}: {
-	// 	findFn: quotaU,
-	// 	dir:    true,
-	// },
+	// RFC 4331 defines the quota properties on collections only, and says
+	// that they "SHOULD NOT be returned by a PROPFIND DAV:allprop request",
+	// as they may be costly to compute.
+	{Space: "DAV:", Local: "quota-available-bytes"}: {
+		findFn:    findQuotaAvailableBytes,
+		dir:       true,
+		dirOnly:   true,
+		noAllprop: true,
+	},
+	{Space: "DAV:", Local: "quota-used-bytes"}: {
+		findFn:    findQuotaUsedBytes,
+		dir:       true,
+		dirOnly:   true,
+		noAllprop: true,
+	},
 	{Space: "DAV:", Local: "getcontenttype"}: {
 		findFn: findContentType,
 		dir:    false,
@@ -199,6 +211,7 @@ func props(ctx context.Context, fs FileSystem, ls LockSystem, pnames []xml.Name,
 
 	pstatOK := Propstat{Status: http.StatusOK}
 	pstatNotFound := Propstat{Status: http.StatusNotFound}
+	pstatFailed := Propstat{Status: http.StatusInternalServerError}
 	for _, pn := range pnames {
 		// If this file has dead properties, check if they contain pn.
 		if dp, ok := deadProps[pn]; ok {
@@ -206,9 +219,22 @@ func props(ctx context.Context, fs FileSystem, ls LockSystem, pnames []xml.Name,
 			continue
 		}
 		// Otherwise, it must either be a live property or we don't know it.
-		if prop := liveProps[pn]; prop.findFn != nil && (prop.dir || !isDir) {
+		if prop := liveProps[pn]; prop.findFn != nil && (prop.dir || !isDir) && (isDir || !prop.dirOnly) {
 			innerXML, err := prop.findFn(ctx, fs, ls, item)
 			//innerXML := "这是属性"
+			if err == errNoProperty {
+				pstatNotFound.Props = append(pstatNotFound.Props, Property{
+					XMLName: pn,
+				})
+				continue
+			}
+			var perr propertyError
+			if errors.As(err, &perr) {
+				pstatFailed.Props = append(pstatFailed.Props, Property{
+					XMLName: pn,
+				})
+				continue
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -222,7 +248,7 @@ func props(ctx context.Context, fs FileSystem, ls LockSystem, pnames []xml.Name,
 			})
 		}
 	}
-	return makePropstats(pstatOK, pstatNotFound), nil
+	return makePropstats(pstatOK, pstatNotFound, pstatFailed), nil
 }
 
 // Propnames returns the property names defined for resource name.
@@ -276,7 +302,7 @@ func propnames(item model.ListModel) ([]xml.Name, error) {
 
 	pnames := make([]xml.Name, 0, len(liveProps)+len(deadProps))
 	for pn, prop := range liveProps {
-		if prop.findFn != nil && (prop.dir || !isDir) {
+		if prop.findFn != nil && (prop.dir || !isDir) && (isDir || !prop.dirOnly) {
 			pnames = append(pnames, pn)
 		}
 	}
@@ -299,11 +325,17 @@ func allprop(ctx context.Context, fs FileSystem, ls LockSystem, include []xml.Na
 	if err != nil {
 		return nil, err
 	}
-	// Add names from include if they are not already covered in pnames.
+	// Leave out the properties only returned by name, then add names from
+	// include if they are not already covered in pnames.
 	nameset := make(map[xml.Name]bool)
+	all := pnames[:0]
 	for _, pn := range pnames {
-		nameset[pn] = true
+		if !liveProps[pn].noAllprop {
+			nameset[pn] = true
+			all = append(all, pn)
+		}
 	}
+	pnames = all
 	for _, pn := range include {
 		if !nameset[pn] {
 			pnames = append(pnames, pn)
@@ -422,18 +454,49 @@ func findCreate(ctx context.Context, fs FileSystem, ls LockSystem, fi model.List
 	return fi.CreatedAt.UTC().Format(http.TimeFormat), nil
 }
 
-// func quota(ctx context.Context, fs FileSystem, ls LockSystem, fi model.ListModel) (string, error) {
-// 	if fi.Name == "" {
-// 		return "1056194496917", nil
-// 	}
-// 	return "", nil
-// }
-// func quotaU(ctx context.Context, fs FileSystem, ls LockSystem, fi model.ListModel) (string, error) {
-// 	if fi.Name == "" {
-// 		return "60497000043", nil
-// 	}
-// 	return "", nil
-// }
+// errNoProperty is returned by the findFn of a live property that the
+// resource does not have after all.
+var errNoProperty = errors.New("webdav: no such property")
+
+// A propertyError is returned by the findFn of a live property whose value
+// could not be determined, such as when a remote call failed. The property
+// is reported with a 500 status rather than failing the whole response.
+type propertyError struct {
+	err error
+}
+
+func (e propertyError) Error() string { return e.err.Error() }
+func (e propertyError) Unwrap() error { return e.err }
+
+// findQuota returns the available and used space of fs, which applies to
+// every resource on it alike.
+func findQuota(ctx context.Context, fs FileSystem) (available, used int64, err error) {
+	q, ok := fs.(QuotaReporter)
+	if !ok {
+		return 0, 0, errNoProperty
+	}
+	available, used, err = q.Quota(ctx)
+	if err != nil {
+		return 0, 0, propertyError{err}
+	}
+	return available, used, nil
+}
+
+func findQuotaAvailableBytes(ctx context.Context, fs FileSystem, ls LockSystem, fi model.ListModel) (string, error) {
+	available, _, err := findQuota(ctx, fs)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(available, 10), nil
+}
+
+func findQuotaUsedBytes(ctx context.Context, fs FileSystem, ls LockSystem, fi model.ListModel) (string, error) {
+	_, used, err := findQuota(ctx, fs)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(used, 10), nil
+}
 
 // ErrNotImplemented should be returned by optional interfaces if they
 // want the original implementation to be used.
@@ -457,7 +520,7 @@ type ContentTyper interface {
 }
 
 func findContentType(ctx context.Context, fs FileSystem, ls LockSystem, fi model.ListModel) (string, error) {
-	return fi.ContentType, nil
+	return contentType(fi), nil
 	//if do, ok := fi.(ContentTyper); ok {
 	//	ctype, err := do.ContentType(ctx)
 	//	if err != ErrNotImplemented {
@@ -0,0 +1,88 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"testing"
+
+	"go-aliyun-webdav/aliyun/model"
+)
+
+// quotaFS is a FileSystem that reports a fixed quota.
+type quotaFS struct {
+	FileSystem
+}
+
+func (quotaFS) Quota(ctx context.Context) (available, used int64, err error) {
+	return 7, 3, nil
+}
+
+func TestQuotaProps(t *testing.T) {
+	ctx := context.Background()
+	fs := quotaFS{NewMemFS()}
+	available := xml.Name{Space: "DAV:", Local: "quota-available-bytes"}
+	used := xml.Name{Space: "DAV:", Local: "quota-used-bytes"}
+	tests := []struct {
+		item   model.ListModel
+		status int
+		want   map[xml.Name]string
+	}{
+		{
+			model.ListModel{Name: "d", Type: "folder"},
+			http.StatusOK,
+			map[xml.Name]string{available: "7", used: "3"},
+		},
+		{
+			model.ListModel{Name: "a.txt", Type: "file", Size: 5},
+			http.StatusNotFound,
+			map[xml.Name]string{available: "", used: ""},
+		},
+	}
+	for _, tt := range tests {
+		pstats, err := props(ctx, fs, NewMemLS(), []xml.Name{available, used}, tt.item)
+		if err != nil {
+			t.Fatalf("props(%s): %v", tt.item.Type, err)
+		}
+		if len(pstats) != 1 || pstats[0].Status != tt.status {
+			t.Fatalf("props(%s) = %v, want a single %d propstat", tt.item.Type, pstats, tt.status)
+		}
+		got := make(map[xml.Name]string)
+		for _, p := range pstats[0].Props {
+			got[p.XMLName] = string(p.InnerXML)
+		}
+		if len(got) != len(tt.want) || got[available] != tt.want[available] || got[used] != tt.want[used] {
+			t.Errorf("props(%s) = %v, want %v", tt.item.Type, got, tt.want)
+		}
+
+		pnames, err := propnames(tt.item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listed := 0
+		for _, pn := range pnames {
+			if pn == available || pn == used {
+				listed++
+			}
+		}
+		if wantListed := map[bool]int{true: 2, false: 0}[tt.item.Type == "folder"]; listed != wantListed {
+			t.Errorf("propnames(%s) lists %d quota properties, want %d", tt.item.Type, listed, wantListed)
+		}
+
+		pstats, err = allprop(ctx, fs, NewMemLS(), nil, tt.item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ps := range pstats {
+			for _, p := range ps.Props {
+				if p.XMLName == available || p.XMLName == used {
+					t.Errorf("allprop(%s) returned %v", tt.item.Type, p.XMLName)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpRange specifies the byte range to be sent to the client.
+type httpRange struct {
+	start, length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// header returns the Range header requesting r from the upstream server.
+func (r httpRange) header() string {
+	return fmt.Sprintf("bytes=%d-%d", r.start, r.start+r.length-1)
+}
+
+func (r httpRange) mimeHeader(contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {r.contentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+var (
+	errInvalidRange       = errors.New("webdav: invalid range")
+	errNoOverlap          = errors.New("webdav: invalid range: failed to overlap")
+	errUpstreamRangeFault = errors.New("webdav: upstream returned an unexpected range")
+)
+
+// parseRange parses a Range header string as per RFC 7233. errNoOverlap is
+// returned if none of the ranges overlap the content. It is the same as the
+// one net/http uses for ServeContent.
+func parseRange(s string, size int64) ([]httpRange, error) {
+	if s == "" {
+		return nil, nil // header not present
+	}
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil, errInvalidRange
+	}
+	var ranges []httpRange
+	noOverlap := false
+	for _, ra := range strings.Split(s[len(b):], ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		i := strings.Index(ra, "-")
+		if i < 0 {
+			return nil, errInvalidRange
+		}
+		start, end := strings.TrimSpace(ra[:i]), strings.TrimSpace(ra[i+1:])
+		var r httpRange
+		if start == "" {
+			// If no start is specified, end specifies the
+			// range start relative to the end of the file,
+			// and we are dealing with <suffix-length>
+			// which has to be a non-negative integer as per
+			// RFC 7233 Section 2.1 "Byte-Ranges".
+			if end == "" || end[0] == '-' {
+				return nil, errInvalidRange
+			}
+			i, err := strconv.ParseInt(end, 10, 64)
+			if i < 0 || err != nil {
+				return nil, errInvalidRange
+			}
+			if i == 0 {
+				// A suffix of zero bytes cannot be satisfied.
+				noOverlap = true
+				continue
+			}
+			if i > size {
+				i = size
+			}
+			r.start = size - i
+			r.length = size - r.start
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, errInvalidRange
+			}
+			if i >= size {
+				// If the range begins after the size of the content,
+				// then it does not overlap.
+				noOverlap = true
+				continue
+			}
+			r.start = i
+			if end == "" {
+				// If no end is specified, range extends to end of the file.
+				r.length = size - r.start
+			} else {
+				i, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || r.start > i {
+					return nil, errInvalidRange
+				}
+				if i >= size {
+					i = size - 1
+				}
+				r.length = i - r.start + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if noOverlap && len(ranges) == 0 {
+		// The specified ranges did not overlap with the content.
+		return nil, errNoOverlap
+	}
+	return ranges, nil
+}
+
+func sumRangesSize(ranges []httpRange) (size int64) {
+	for _, ra := range ranges {
+		size += ra.length
+	}
+	return
+}
+
+// checkIfRange reports whether the Range header of r applies, given the
+// If-Range header and the current ETag and modification time.
+func checkIfRange(r *http.Request, etag string, modtime time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		// Only a strong ETag may validate a range.
+		return !strings.HasPrefix(ir, "W/") && ir == etag
+	}
+	t, err := http.ParseTime(ir)
+	if err != nil || modtime.IsZero() {
+		return false
+	}
+	return modtime.Truncate(time.Second).Equal(t)
+}
+
+// relayedHeaders are the headers of the upstream response passed on to the
+// client, unless the Handler has already set them from the file's metadata.
+var relayedHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", "Cache-Control", "Content-Disposition"}
+
+// serveDownload serves the content of the file described by fi, fetching
+// it through d, with the byte ranges requested by r. Ranges are resolved
+// against the known file size here and fetched upstream as explicit byte
+// spans, so that suffix ranges, multiple ranges and unsatisfiable ranges
+// behave as RFC 7233 says regardless of the upstream server.
+func serveDownload(w http.ResponseWriter, r *http.Request, d Downloader, fi os.FileInfo, etag string) (int, error) {
+	ctx := r.Context()
+	size := fi.Size()
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	var ranges []httpRange
+	if checkIfRange(r, etag, fi.ModTime()) {
+		var err error
+		ranges, err = parseRange(r.Header.Get("Range"), size)
+		if err != nil {
+			if err == errNoOverlap {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			}
+			return http.StatusRequestedRangeNotSatisfiable, err
+		}
+		if sumRangesSize(ranges) > size {
+			// The total number of bytes in all the ranges is larger
+			// than the size of the file, so serve it whole instead.
+			ranges = nil
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		res, err := download(ctx, d, "")
+		if err != nil {
+			return errorStatus(err, http.StatusBadGateway), err
+		}
+		defer res.Body.Close()
+		relayHeaders(w, res)
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, res.Body)
+		return 0, nil
+
+	case 1:
+		ra := ranges[0]
+		res, err := download(ctx, d, ra.header())
+		if err != nil {
+			return errorStatus(err, http.StatusBadGateway), err
+		}
+		defer res.Body.Close()
+		body, err := rangeBody(res, ra)
+		if err != nil {
+			return http.StatusBadGateway, err
+		}
+		relayHeaders(w, res)
+		w.Header().Set("Content-Range", ra.contentRange(size))
+		w.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		io.Copy(w, body)
+		return 0, nil
+	}
+
+	// Several ranges are served as multipart/byteranges, fetching one
+	// range after the other upstream.
+	partType := contentType(fileModel(fi))
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusPartialContent)
+	for _, ra := range ranges {
+		res, err := download(ctx, d, ra.header())
+		if err != nil {
+			// The status line has gone out already; cut the
+			// response short so the client notices.
+			return 0, err
+		}
+		body, err := rangeBody(res, ra)
+		if err == nil {
+			var part io.Writer
+			if part, err = mw.CreatePart(ra.mimeHeader(partType, size)); err == nil {
+				_, err = io.Copy(part, body)
+			}
+		}
+		res.Body.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+	mw.Close()
+	return 0, nil
+}
+
+// download requests the content of d with the Range header rangeStr, and
+// turns an error status of the upstream server into an error.
+func download(ctx context.Context, d Downloader, rangeStr string) (*http.Response, error) {
+	res, err := d.Download(ctx, rangeStr, "")
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
+		return nil, &upstreamError{res.StatusCode}
+	}
+	return res, nil
+}
+
+// rangeBody returns the bytes of ra in the body of res. An upstream server
+// that ignored the Range header and answered 200 is served from its full
+// body.
+func rangeBody(res *http.Response, ra httpRange) (io.Reader, error) {
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		if cr := res.Header.Get("Content-Range"); cr != "" && !strings.HasPrefix(cr, fmt.Sprintf("bytes %d-", ra.start)) {
+			return nil, errUpstreamRangeFault
+		}
+	case http.StatusOK:
+		if _, err := io.CopyN(ioutil.Discard, res.Body, ra.start); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errUpstreamRangeFault
+	}
+	return io.LimitReader(res.Body, ra.length), nil
+}
+
+func relayHeaders(w http.ResponseWriter, res *http.Response) {
+	for _, k := range relayedHeaders {
+		if v := res.Header.Get(k); v != "" && w.Header().Get(k) == "" {
+			w.Header().Set(k, v)
+		}
+	}
+}
+
+// An upstreamError is an error status returned by the server a Downloader
+// fetches content from.
+type upstreamError struct {
+	status int
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("webdav: upstream responded %d %s", e.status, http.StatusText(e.status))
+}
+
+// HTTPStatus returns the status to answer the client with.
+func (e *upstreamError) HTTPStatus() int {
+	switch e.status {
+	case http.StatusNotFound, http.StatusRequestedRangeNotSatisfiable:
+		return e.status
+	}
+	return http.StatusBadGateway
+}
@@ -0,0 +1,249 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webdav
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		s       string
+		size    int64
+		want    []httpRange
+		wantErr error
+	}{
+		{"", 10, nil, nil},
+		{"bytes=0-4", 10, []httpRange{{0, 5}}, nil},
+		{"bytes=2-", 10, []httpRange{{2, 8}}, nil},
+		{"bytes=-3", 10, []httpRange{{7, 3}}, nil},
+		{"bytes=-30", 10, []httpRange{{0, 10}}, nil},
+		{"bytes=5-100", 10, []httpRange{{5, 5}}, nil},
+		{"bytes=0-0,-1", 10, []httpRange{{0, 1}, {9, 1}}, nil},
+		{"bytes= 1-2 , 4-5", 10, []httpRange{{1, 2}, {4, 2}}, nil},
+		{"bytes=20-,1-2", 10, []httpRange{{1, 2}}, nil},
+		{"bytes=10-", 10, nil, errNoOverlap},
+		{"bytes=-0", 10, nil, errNoOverlap},
+		{"bytes=0-", 0, nil, errNoOverlap},
+		{"bits=0-4", 10, nil, errInvalidRange},
+		{"bytes=4", 10, nil, errInvalidRange},
+		{"bytes=4-2", 10, nil, errInvalidRange},
+		{"bytes=-", 10, nil, errInvalidRange},
+		{"bytes=--3", 10, nil, errInvalidRange},
+		{"bytes=a-b", 10, nil, errInvalidRange},
+	}
+	for _, tt := range tests {
+		got, err := parseRange(tt.s, tt.size)
+		if err != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRange(%q, %d) = %v, %v; want %v, %v", tt.s, tt.size, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckIfRange(t *testing.T) {
+	modtime := time.Date(2021, 8, 1, 12, 0, 0, 500, time.UTC)
+	tests := []struct {
+		ifRange string
+		etag    string
+		modtime time.Time
+		want    bool
+	}{
+		{"", `"x"`, modtime, true},
+		{`"x"`, `"x"`, modtime, true},
+		{`"y"`, `"x"`, modtime, false},
+		{`W/"x"`, `W/"x"`, modtime, false},
+		{modtime.Format(http.TimeFormat), `"x"`, modtime, true},
+		{modtime.Add(time.Second).Format(http.TimeFormat), `"x"`, modtime, false},
+		{modtime.Format(http.TimeFormat), `"x"`, time.Time{}, false},
+		{"yesterday", `"x"`, modtime, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/a.txt", nil)
+		if tt.ifRange != "" {
+			r.Header.Set("If-Range", tt.ifRange)
+		}
+		if got := checkIfRange(r, tt.etag, tt.modtime); got != tt.want {
+			t.Errorf("checkIfRange(%q, %q, %v) = %t, want %t", tt.ifRange, tt.etag, tt.modtime, got, tt.want)
+		}
+	}
+}
+
+func TestRangeBody(t *testing.T) {
+	tests := []struct {
+		status       int
+		contentRange string
+		body         string
+		ra           httpRange
+		want         string
+		wantErr      bool
+	}{
+		{http.StatusPartialContent, "bytes 2-4/10", "234", httpRange{2, 3}, "234", false},
+		{http.StatusPartialContent, "", "234", httpRange{2, 3}, "234", false},
+		{http.StatusPartialContent, "bytes 2-9/10", "23456789", httpRange{2, 3}, "234", false},
+		{http.StatusPartialContent, "bytes 0-4/10", "01234", httpRange{2, 3}, "", true},
+		{http.StatusOK, "", "0123456789", httpRange{2, 3}, "234", false},
+		{http.StatusOK, "", "0", httpRange{2, 3}, "", true},
+		{http.StatusNoContent, "", "", httpRange{2, 3}, "", true},
+	}
+	for _, tt := range tests {
+		res := &http.Response{
+			StatusCode: tt.status,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(tt.body)),
+		}
+		if tt.contentRange != "" {
+			res.Header.Set("Content-Range", tt.contentRange)
+		}
+		body, err := rangeBody(res, tt.ra)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("rangeBody(%d %q, %v): %v, want error %t", tt.status, tt.contentRange, tt.ra, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if b, _ := ioutil.ReadAll(body); string(b) != tt.want {
+			t.Errorf("rangeBody(%d %q, %v) = %q, want %q", tt.status, tt.contentRange, tt.ra, b, tt.want)
+		}
+	}
+}
+
+// fakeDownloader serves content the way a storage server does, honouring
+// the Range header unless ignoreRange is set, and records the Range headers
+// it was asked for. A non-zero status is answered instead.
+type fakeDownloader struct {
+	content     string
+	ignoreRange bool
+	status      int
+	ranges      []string
+}
+
+func (d *fakeDownloader) Download(ctx context.Context, rangeStr, ifRange string) (*http.Response, error) {
+	d.ranges = append(d.ranges, rangeStr)
+	w := httptest.NewRecorder()
+	if d.status != 0 {
+		http.Error(w, http.StatusText(d.status), d.status)
+		return w.Result(), nil
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	if rangeStr != "" && !d.ignoreRange {
+		r.Header.Set("Range", rangeStr)
+	}
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(d.content))
+	return w.Result(), nil
+}
+
+func TestServeDownload(t *testing.T) {
+	const content = "0123456789"
+	fi := &memFileInfo{name: "a.txt", size: int64(len(content)), modTime: time.Now()}
+	tests := []struct {
+		name         string
+		rangeHdr     string
+		ignoreRange  bool
+		status       int
+		wantStatus   int
+		wantBody     string
+		wantRange    string
+		wantUpstream []string
+	}{
+		{"whole", "", false, 0, 200, content, "", []string{""}},
+		{"range", "bytes=2-4", false, 0, 206, "234", "bytes 2-4/10", []string{"bytes=2-4"}},
+		{"suffix", "bytes=-3", false, 0, 206, "789", "bytes 7-9/10", []string{"bytes=7-9"}},
+		{"open", "bytes=7-", false, 0, 206, "789", "bytes 7-9/10", []string{"bytes=7-9"}},
+		{"range ignored upstream", "bytes=2-4", true, 0, 206, "234", "bytes 2-4/10", []string{"bytes=2-4"}},
+		{"ranges beyond size", "bytes=0-9,0-9", false, 0, 200, content, "", []string{""}},
+		{"no overlap", "bytes=20-", false, 0, 416, "", "bytes */10", nil},
+		{"invalid", "bytes=4-2", false, 0, 416, "", "", nil},
+		{"upstream not found", "", false, 404, 404, "", "", []string{""}},
+		{"upstream failure", "bytes=2-4", false, 500, 502, "", "", []string{"bytes=2-4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &fakeDownloader{content: content, ignoreRange: tt.ignoreRange, status: tt.status}
+			r := httptest.NewRequest("GET", "/a.txt", nil)
+			if tt.rangeHdr != "" {
+				r.Header.Set("Range", tt.rangeHdr)
+			}
+			w := httptest.NewRecorder()
+			status, _ := serveDownload(w, r, d, fi, `"etag"`)
+			if status == 0 {
+				status = w.Code
+			}
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.wantRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantRange)
+			}
+			if !reflect.DeepEqual(d.ranges, tt.wantUpstream) {
+				t.Errorf("upstream ranges = %q, want %q", d.ranges, tt.wantUpstream)
+			}
+		})
+	}
+}
+
+func TestServeDownloadMultipart(t *testing.T) {
+	const content = "0123456789"
+	fi := &memFileInfo{name: "a.txt", size: int64(len(content)), modTime: time.Now()}
+	for _, ignoreRange := range []bool{false, true} {
+		d := &fakeDownloader{content: content, ignoreRange: ignoreRange}
+		r := httptest.NewRequest("GET", "/a.txt", nil)
+		r.Header.Set("Range", "bytes=0-1,-2")
+		w := httptest.NewRecorder()
+		if status, err := serveDownload(w, r, d, fi, `"etag"`); status != 0 || err != nil {
+			t.Fatal(status, err)
+		}
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("status = %d", w.Code)
+		}
+		mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("Content-Type = %q", w.Header().Get("Content-Type"))
+		}
+		want := []struct{ contentRange, body string }{
+			{"bytes 0-1/10", "01"},
+			{"bytes 8-9/10", "89"},
+		}
+		mr := multipart.NewReader(w.Body, params["boundary"])
+		for i := 0; ; i++ {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				if i != len(want) {
+					t.Errorf("%d parts, want %d", i, len(want))
+				}
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i >= len(want) {
+				t.Fatalf("unexpected part %d", i)
+			}
+			b, _ := ioutil.ReadAll(p)
+			if cr := p.Header.Get("Content-Range"); cr != want[i].contentRange || string(b) != want[i].body {
+				t.Errorf("part %d = %q %q, want %q %q", i, cr, b, want[i].contentRange, want[i].body)
+			}
+			if ct := p.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+				t.Errorf("part %d Content-Type = %q", i, ct)
+			}
+		}
+		if want := []string{"bytes=0-1", "bytes=8-9"}; !reflect.DeepEqual(d.ranges, want) {
+			t.Errorf("upstream ranges = %q, want %q", d.ranges, want)
+		}
+	}
+}
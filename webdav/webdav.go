@@ -6,21 +6,17 @@
 package webdav // import "golang.org/x/net/webdav"
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"go-aliyun-webdav/aliyun"
-	"go-aliyun-webdav/aliyun/cache"
-	"go-aliyun-webdav/aliyun/model"
 	"io"
-	"io/ioutil"
-	"reflect"
 	"strconv"
 
 	"net/http"
 	"net/url"
 	"os"
 	"path"
-	"runtime"
+	"sort"
 	"strings"
 	"time"
 )
@@ -35,9 +31,21 @@ type Handler struct {
 	// Logger is an optional error logger. If non-nil, it will be called
 	// for all HTTP requests.
 	Logger func(*http.Request, error)
-	Config model.Config
+	// Redirect is an optional download policy. If non-nil and it returns
+	// true for a GET request, files implementing Redirector are answered
+	// with a redirect to where their content can be downloaded, instead of
+	// having the content proxied through the Handler.
+	Redirect func(*http.Request) bool
+	// MaxPropfindNodes is how many resources a PROPFIND with "Depth:
+	// infinity" may report. Requests for larger trees are refused. If zero,
+	// DefaultMaxPropfindNodes is used. If negative, all such requests are
+	// refused.
+	MaxPropfindNodes int
 }
 
+// DefaultMaxPropfindNodes is the default for Handler.MaxPropfindNodes.
+const DefaultMaxPropfindNodes = 10000
+
 func (h *Handler) stripPrefix(p string) (string, int, error) {
 	if h.Prefix == "" {
 		return p, http.StatusOK, nil
@@ -50,38 +58,33 @@ func (h *Handler) stripPrefix(p string) (string, int, error) {
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	status, err := http.StatusBadRequest, errUnsupportedMethod
-	if h.Config.ExpireTime < time.Now().Unix()-100 {
-		refreshResult := aliyun.RefreshToken(h.Config.RefreshToken)
-		config := model.Config{
-			RefreshToken: refreshResult.RefreshToken,
-			Token:        refreshResult.AccessToken,
-			DriveId:      refreshResult.DefaultDriveId,
-			ExpireTime:   time.Now().Unix() + refreshResult.ExpiresIn,
+	if h.FileSystem == nil {
+		status, err = http.StatusInternalServerError, errNoFileSystem
+	} else if h.LockSystem == nil {
+		status, err = http.StatusInternalServerError, errNoLockSystem
+	} else {
+		switch r.Method {
+		case "OPTIONS":
+			status, err = h.handleOptions(w, r)
+		case "GET", "HEAD", "POST":
+			status, err = h.handleGetHeadPost(w, r)
+		case "DELETE":
+			status, err = h.handleDelete(w, r)
+		case "PUT":
+			status, err = h.handlePut(w, r)
+		case "MKCOL":
+			status, err = h.handleMkcol(w, r)
+		case "COPY", "MOVE":
+			status, err = h.handleCopyMove(w, r)
+		case "LOCK":
+			status, err = h.handleLock(w, r)
+		case "UNLOCK":
+			status, err = h.handleUnlock(w, r)
+		case "PROPFIND":
+			status, err = h.handlePropfind(w, r)
+		case "PROPPATCH":
+			status, err = h.handleProppatch(w, r)
 		}
-		h.Config = config
-	}
-
-	switch r.Method {
-	case "OPTIONS":
-		status, err = h.handleOptions(w, r)
-	case "GET", "HEAD", "POST":
-		status, err = h.handleGetHeadPost(w, r)
-	case "DELETE":
-		status, err = h.handleDelete(w, r)
-	case "PUT":
-		status, err = h.handlePut(w, r)
-	case "MKCOL":
-		status, err = h.handleMkcol(w, r)
-	case "COPY", "MOVE":
-		status, err = h.handleCopyMove(w, r)
-	case "LOCK":
-		status, err = h.handleLock(w, r)
-	case "UNLOCK":
-		status, err = h.handleUnlock(w, r)
-	case "PROPFIND":
-		status, err = h.handlePropfind(w, r)
-	case "PROPPATCH":
-		status, err = h.handleProppatch(w, r)
 	}
 
 	if status != 0 {
@@ -206,90 +209,94 @@ func (h *Handler) handleOptions(w http.ResponseWriter, r *http.Request) (status
 }
 
 func (h *Handler) handleGetHeadPost(w http.ResponseWriter, r *http.Request) (status int, err error) {
-	//var data []byte
-	var fi model.ListModel
 	reqPath, status, err := h.stripPrefix(r.URL.Path)
-	if len(reqPath) > 0 && !strings.HasSuffix(reqPath, "/") {
-		strArr := strings.Split(reqPath, "/")
-
-		list, err := aliyun.GetList(h.Config.Token, h.Config.DriveId, "")
-		if err != nil {
-			return http.StatusNotFound, err
-		}
-
-		fi, err = findUrl(strArr, h.Config.Token, h.Config.DriveId, list)
-		if err != nil || fi.FileId == "" {
-			return http.StatusNotFound, err
-		}
-		//url := fi.Thumbnail
-		//url := fi.Url
-		//if len(url) == 0 {
-		//url=fi.Url
-		//}
-		rangeStr := r.Header.Get("range")
-
-		if len(rangeStr) > 0 && strings.LastIndex(rangeStr, "-") > 0 {
-			rangeArr := strings.Split(rangeStr, "-")
-			rangEnd, _ := strconv.ParseInt(rangeArr[1], 10, 64)
-			if rangEnd >= fi.Size {
-				rangeStr = rangeStr[:strings.LastIndex(rangeStr, "-")+1]
-			}
+	if err != nil {
+		return status, err
+	}
+	// TODO: check locks for read-only access??
+	ctx := r.Context()
+	f, err := h.FileSystem.OpenFile(ctx, reqPath, os.O_RDONLY, 0)
+	if err != nil {
+		return errorStatus(err, http.StatusNotFound), err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	etag, err := findETag(ctx, h.FileSystem, h.LockSystem, fileModel(fi))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.Header().Set("ETag", etag)
+	if fi.IsDir() {
+		if r.Method == "POST" {
+			return http.StatusMethodNotAllowed, nil
 		}
-		//rangeStr = "bytes=0-" + strconv.Itoa(fi.Size)
-		if r.Method != "HEAD" {
-			if strings.Index(r.URL.String(), "025.jpg") > 0 {
-			}
-			downloadUrl := aliyun.GetDownloadUrl(h.Config.Token, h.Config.DriveId, fi.FileId)
-			aliyun.GetFile(w, downloadUrl, h.Config.Token, rangeStr, r.Header.Get("if-range"))
+		setFileHeaders(w, fi)
+		if r.Method == "HEAD" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			return 0, nil
 		}
+		return h.serveDirList(w, r, reqPath, f)
+	}
 
-		if fi.Type == "folder" {
-			return http.StatusMethodNotAllowed, nil
-		}
-		ctx := r.Context()
-		etag, err := findETag(ctx, h.FileSystem, h.LockSystem, fi)
+	if rd, ok := f.(Redirector); ok && r.Method == "GET" && h.Redirect != nil && h.Redirect(r) {
+		u, err := rd.DownloadURL(ctx)
 		if err != nil {
-			return http.StatusInternalServerError, err
+			return errorStatus(err, http.StatusBadGateway), err
 		}
-		w.Header().Set("ETag", etag)
+		http.Redirect(w, r, u, http.StatusFound)
+		return 0, nil
+	}
 
-		//http.ServeContent(w, r, reqPath, int64(fi.Size), fi.UpdatedAt)
+	d, ok := f.(Downloader)
+	if !ok {
+		// Let ServeContent determine the Content-Type header.
+		http.ServeContent(w, r, reqPath, fi.ModTime(), f)
 		return 0, nil
-		//for _, i := range list.Items {
-		//	if i.Name == reqPath {
-		//		fi = i
-		//		data = aliyun.GetFile(i.Url, h.Config.Token)
-		//		break
-		//	}
-		//}
 	}
+	setFileHeaders(w, fi)
+	if notModified(r, etag, fi.ModTime()) {
+		w.WriteHeader(http.StatusNotModified)
+		return 0, nil
+	}
+	if r.Method == "HEAD" {
+		w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+		return 0, nil
+	}
+	return serveDownload(w, r, d, fi, etag)
+}
 
-	if err != nil {
-		return status, err
+// setFileHeaders sets the metadata headers of a GET or HEAD response for
+// the resource described by fi. Content-Length is left to the caller, as it
+// depends on the ranges served.
+func setFileHeaders(w http.ResponseWriter, fi os.FileInfo) {
+	if !fi.IsDir() {
+		w.Header().Set("Content-Type", contentType(fileModel(fi)))
+		w.Header().Set("Accept-Ranges", "bytes")
 	}
-	// TODO: check locks for read-only access??
+	if t := fi.ModTime(); !t.IsZero() && t.Unix() != 0 {
+		w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+}
 
-	//f, err := h.FileSystem.OpenFile(ctx, reqPath, os.O_RDONLY, 0)
-	//if err != nil {
-	//	return http.StatusNotFound, err
-	//}
-	//defer f.Close()
-	//fi, err := f.Stat()
-	//if err != nil {
-	//	return http.StatusNotFound, err
-	//}
-	if fi.Type == "folder" {
-		return http.StatusMethodNotAllowed, nil
-	}
-	ctx1 := r.Context()
-	etag, err := findETag(ctx1, h.FileSystem, h.LockSystem, fi)
+// notModified reports whether a GET or HEAD request r can be answered with
+// 304 Not Modified, given the ETag and modification time of the resource.
+// As in RFC 7232, If-Modified-Since is ignored when If-None-Match is given.
+func notModified(r *http.Request, etag string, modtime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagListMatches(inm, etag, true)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modtime.IsZero() || modtime.Unix() == 0 {
+		return false
+	}
+	t, err := http.ParseTime(ims)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return false
 	}
-	w.Header().Set("ETag", etag)
-
-	//http.ServeContent(w, r, reqPath, 0, fi.UpdatedAt)
-	return 0, nil
+	return !modtime.Truncate(time.Second).After(t)
 }
 
 func (h *Handler) handleDelete(w http.ResponseWriter, r *http.Request) (status int, err error) {
@@ -297,47 +304,35 @@ func (h *Handler) handleDelete(w http.ResponseWriter, r *http.Request) (status i
 	if err != nil {
 		return status, err
 	}
+	release, status, err := h.confirmLocks(r, reqPath, "")
+	if err != nil {
+		return status, err
+	}
+	defer release()
 
-	var fi model.ListModel
-	if len(reqPath) > 0 && !strings.HasSuffix(reqPath, "/") {
-		strArr := strings.Split(reqPath, "/")
-		list, _ := aliyun.GetList(h.Config.Token, h.Config.DriveId, "")
-		fi, _ = findUrl(strArr, h.Config.Token, h.Config.DriveId, list)
-
-		//for _, i := range list.Items {
-		//	if i.Name == reqPath {
-		//		fi = i
-		//		data = aliyun.GetFile(i.Url, h.Config.Token)
-		//		break
-		//	}
-		//}
-	}
-
-	//release, status, err := h.confirmLocks(r, reqPath, "")
-	//if err != nil {
-	//	return status, err
-	//}
-	//defer release()
-
-	//ctx := r.Context()
-	//
-	//// TODO: return MultiStatus where appropriate.
-	//
-	//// "godoc os RemoveAll" says that "If the path does not exist, RemoveAll
-	//// returns nil (no error)." WebDAV semantics are that it should return a
-	//// "404 Not Found". We therefore have to Stat before we RemoveAll.
-	//if _, err := h.FileSystem.Stat(ctx, reqPath); err != nil {
-	//	if os.IsNotExist(err) {
-	//		return http.StatusNotFound, err
-	//	}
-	//	return http.StatusMethodNotAllowed, err
-	//}
-	////if err := h.FileSystem.RemoveAll(ctx, reqPath); err != nil {
-	////	return http.StatusMethodNotAllowed, err
-	////}
-
-	aliyun.RemoveTrash(h.Config.Token, h.Config.DriveId, fi.FileId, fi.ParentFileId)
+	// Section 9.6.1 says that "A client MUST NOT submit a Depth header with
+	// a DELETE on a collection with any value but infinity."
+	if hdr := r.Header.Get("Depth"); hdr != "" && parseDepth(hdr) != infiniteDepth {
+		return http.StatusBadRequest, errInvalidDepth
+	}
 
+	ctx := r.Context()
+
+	// "godoc os RemoveAll" says that "If the path does not exist, RemoveAll
+	// returns nil (no error)." WebDAV semantics are that it should return a
+	// "404 Not Found". We therefore have to Stat before we RemoveAll.
+	if _, err := h.FileSystem.Stat(ctx, reqPath); err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusNotFound, err
+		}
+		return errorStatus(err, http.StatusMethodNotAllowed), err
+	}
+	// RemoveAll removes a collection all or nothing, as the drive does for
+	// a folder, so no member can fail on its own and DELETE is never
+	// answered with a Multi-Status response.
+	if err := h.FileSystem.RemoveAll(ctx, reqPath); err != nil {
+		return errorStatus(err, http.StatusMethodNotAllowed), err
+	}
 	return http.StatusNoContent, nil
 }
 
@@ -349,121 +344,179 @@ func (h *Handler) handlePut(w http.ResponseWriter, r *http.Request) (status int,
 	if strings.Index(r.Header.Get("User-Agent"), "Darwin") > -1 && strings.Index(reqPath, "._") > -1 {
 		return status, err
 	}
-	lastIndex := strings.LastIndex(reqPath, "/")
-	fileName := reqPath[lastIndex+1:]
-	if lastIndex == -1 {
-		lastIndex = 0
-		fileName = reqPath
-	}
-	var fi model.ListModel
-	if len(reqPath) > 0 && !strings.HasSuffix(reqPath, "/") {
-		strArr := strings.Split(reqPath[:lastIndex], "/")
-		list, _ := aliyun.GetList(h.Config.Token, h.Config.DriveId, "")
-		fi, _ = findUrl(strArr, h.Config.Token, h.Config.DriveId, list)
-
-		//for _, i := range list.Items {
-		//	if i.Name == reqPath {
-		//		fi = i
-		//		data = aliyun.GetFile(i.Url, h.Config.Token)
-		//		break
-		//	}
-		//}
-	}
-
-	if r.ContentLength == 0 {
-		if reflect.DeepEqual(fi, model.ListModel{}) {
-			fi.FileId = "root"
-		}
-		list, _ := aliyun.GetList(h.Config.Token, h.Config.DriveId, fi.FileId)
-		var item model.ListModel
-		item.ParentFileId = fi.FileId
-		item.Name = fileName
-		list.Items = append(list.Items, item)
-		cache.GoCache.SetDefault(fi.FileId, list)
-
-		defer r.Body.Close()
-		return http.StatusCreated, nil
-	}
-
-	aliyun.ContentHandle(r, h.Config.Token, h.Config.DriveId, fi.FileId, fileName)
-
-	//	aliyun.UploadFile(uploadUrl, h.Config.Token, data)
-	//	aliyun.UploadFileComplete(h.Config.Token, h.Config.DriveId, uploadId, fileId)
-	//	release, status, err := h.confirmLocks(r, reqPath, "")
-	//	if err != nil {
-	//		return status, err
-	//	}
-	//	defer release()
-	//	// TODO(rost): Support the If-Match, If-None-Match headers? See bradfitz'
-	//	// comments in http.checkEtag.
+	release, status, err := h.confirmLocks(r, reqPath, "")
+	if err != nil {
+		return status, err
+	}
+	defer release()
 	ctx := r.Context()
-	//
-	//
-	f, err := h.FileSystem.OpenFile(ctx, "./aaa.png", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	defer r.Body.Close()
+
+	// A PUT to an existing file replaces it. Report whether one existed,
+	// and let the client make the request depend on it.
+	existed := false
+	etag := ""
+	if fi, err := h.FileSystem.Stat(ctx, reqPath); err == nil {
+		if fi.IsDir() {
+			return http.StatusMethodNotAllowed, nil
+		}
+		existed = true
+		if etag, err = findETag(ctx, h.FileSystem, h.LockSystem, fileModel(fi)); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return errorStatus(err, http.StatusInternalServerError), err
+	}
+	if !checkPutPreconditions(r, existed, etag) {
+		return http.StatusPreconditionFailed, nil
+	}
+	created := http.StatusCreated
+	if existed {
+		created = http.StatusNoContent
+	}
+
+	if p, ok := h.FileSystem.(Putter); ok {
+		if err := p.Put(ctx, reqPath, r.Body, r.ContentLength); err != nil {
+			if os.IsNotExist(err) {
+				return http.StatusConflict, err
+			}
+			return errorStatus(err, http.StatusMethodNotAllowed), err
+		}
+		if fi, err := h.FileSystem.Stat(ctx, reqPath); err == nil {
+			if etag, err := findETag(ctx, h.FileSystem, h.LockSystem, fileModel(fi)); err == nil {
+				w.Header().Set("ETag", etag)
+			}
+		}
+		return created, nil
+	}
+
+	f, err := h.FileSystem.OpenFile(ctx, reqPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return http.StatusNotFound, err
 	}
-	//	fmt.Println(r.PostForm.Encode())
-	//		bts, err := ioutil.ReadAll(r.Body)
-	defer r.Body.Close()
-	//	fmt.Println(string(bts))
 	_, copyErr := io.Copy(f, r.Body)
-	//fif, statErr := f.Stat()
+	fi, statErr := f.Stat()
 	closeErr := f.Close()
-	//	// TODO(rost): Returning 405 Method Not Allowed might not be appropriate.
+	// TODO(rost): Returning 405 Method Not Allowed might not be appropriate.
 	if copyErr != nil {
 		return http.StatusMethodNotAllowed, copyErr
 	}
-	//if statErr != nil {
-	//	return http.StatusMethodNotAllowed, statErr
-	//}
+	if statErr != nil {
+		return http.StatusMethodNotAllowed, statErr
+	}
 	if closeErr != nil {
 		return http.StatusMethodNotAllowed, closeErr
 	}
-	//etag, err := findETag(ctx, h.FileSystem, h.LockSystem, model.ListModel{})
-	//if err != nil {
-	//	return http.StatusInternalServerError, err
-	//}
-	//w.Header().Set("ETag", etag)
-	return http.StatusCreated, nil
+	etag, err = findETag(ctx, h.FileSystem, h.LockSystem, fileModel(fi))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.Header().Set("ETag", etag)
+	return created, nil
+}
+
+// checkPutPreconditions reports whether a PUT may go ahead under the
+// If-Match and If-None-Match headers of r, given whether the target exists
+// and its ETag. If-Match compares strongly and If-None-Match weakly, as in
+// RFC 7232.
+func checkPutPreconditions(r *http.Request, exists bool, etag string) bool {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !exists || !etagListMatches(im, etag, false) {
+			return false
+		}
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if exists && etagListMatches(inm, etag, true) {
+			return false
+		}
+	}
+	return true
+}
+
+// etagListMatches reports whether the comma-separated If-Match or
+// If-None-Match value list holds "*" or etag.
+func etagListMatches(list, etag string, weak bool) bool {
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			return true
+		}
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		} else if strings.HasPrefix(t, "W/") {
+			continue
+		}
+		if t == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
 }
 
 func (h *Handler) handleMkcol(w http.ResponseWriter, r *http.Request) (status int, err error) {
 	reqPath, status, err := h.stripPrefix(r.URL.Path)
-	if strings.HasSuffix(reqPath, "/") {
-		reqPath = reqPath[:len(reqPath)-1]
+	if err != nil {
+		return status, err
 	}
+	release, status, err := h.confirmLocks(r, reqPath, "")
 	if err != nil {
 		return status, err
 	}
+	defer release()
+
+	ctx := r.Context()
 
 	if r.ContentLength > 0 {
 		return http.StatusUnsupportedMediaType, nil
 	}
-
-	if len(reqPath) > 0 {
-		parentFileId := "root"
-		var name string = reqPath
-		var fi model.ListModel
-		index := strings.LastIndex(reqPath[0:len(reqPath)], "/")
-		if index > -1 {
-			strArr := strings.Split(reqPath[0:index], "/")
-			list, _ := aliyun.GetList(h.Config.Token, h.Config.DriveId, "")
-			fi, _ = findUrl(strArr, h.Config.Token, h.Config.DriveId, list)
-			parentFileId = fi.FileId
-			name = reqPath[index+1:]
+	if r.Header.Get("X-Mkcol-Parents") == "T" {
+		if status, err := h.mkcolParents(ctx, reqPath); err != nil {
+			return status, err
+		}
+	}
+	if err := h.FileSystem.Mkdir(ctx, reqPath, 0777); err != nil {
+		// Section 9.3.1 says that a MKCOL on an existing resource fails
+		// with "405 (Method Not Allowed)", and one whose parent is
+		// missing with "409 (Conflict)".
+		if errors.Is(err, os.ErrExist) {
+			return http.StatusMethodNotAllowed, err
 		}
-		aliyun.MakeDir(h.Config.Token, h.Config.DriveId, name, parentFileId)
-	}
-	//if err := h.FileSystem.Mkdir(ctx, reqPath, 0777); err != nil {
-	//	if os.IsNotExist(err) {
-	//		return http.StatusConflict, err
-	//	}
-	//	return http.StatusMethodNotAllowed, err
-	//}
+		if os.IsNotExist(err) {
+			return http.StatusConflict, err
+		}
+		return errorStatus(err, http.StatusMethodNotAllowed), err
+	}
 	return http.StatusCreated, nil
 }
 
+// mkcolParents creates the missing ancestors of reqPath, like mkdir -p. A
+// client asks for it with the "X-Mkcol-Parents: T" header, which is not
+// part of WebDAV, to create a deep tree in one request.
+func (h *Handler) mkcolParents(ctx context.Context, reqPath string) (int, error) {
+	dir := path.Dir(slashClean(reqPath))
+	if dir == "/" {
+		return 0, nil
+	}
+	name := "/"
+	for _, frag := range strings.Split(dir[1:], "/") {
+		name = path.Join(name, frag)
+		fi, err := h.FileSystem.Stat(ctx, name)
+		if err == nil {
+			if !fi.IsDir() {
+				return http.StatusConflict, errNotADirectory
+			}
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return errorStatus(err, http.StatusInternalServerError), err
+		}
+		if err := h.FileSystem.Mkdir(ctx, name, 0777); err != nil && !errors.Is(err, os.ErrExist) {
+			return errorStatus(err, http.StatusConflict), err
+		}
+	}
+	return 0, nil
+}
+
 func (h *Handler) handleCopyMove(w http.ResponseWriter, r *http.Request) (status int, err error) {
 	hdr := r.Header.Get("Destination")
 	if hdr == "" {
@@ -495,61 +548,12 @@ func (h *Handler) handleCopyMove(w http.ResponseWriter, r *http.Request) (status
 		return http.StatusBadGateway, errInvalidDestination
 	}
 
-	srcIndex := strings.LastIndex(src, "/")
-	dstIndex := -1
-	if runtime.GOOS == "darwin" {
-		dstIndex = len(dst)
-	} else {
-		dstIndex = strings.LastIndex(dst, "/")
-	}
-
-	rename := false
-	if srcIndex == -1 && srcIndex == dstIndex {
-		rename = true
-	} else {
-		if srcIndex == dstIndex {
-			srcPrefix := src[:srcIndex+1]
-			dstPrefix := dst[:dstIndex+1]
-			if srcPrefix == dstPrefix {
-				rename = true
-			}
-		}
-	}
-
-	if rename {
-		var fi model.ListModel
-		strArr := strings.Split(src, "/")
-		list, _ := aliyun.GetList(h.Config.Token, h.Config.DriveId, "")
-		fi, _ = findUrl(strArr, h.Config.Token, h.Config.DriveId, list)
-
-		if dstIndex == -1 {
-			dstIndex = 0
-		} else {
-			dstIndex += 1
-		}
-		aliyun.ReName(h.Config.Token, h.Config.DriveId, dst[dstIndex:], fi.FileId)
-		return http.StatusNoContent, nil
-	}
-
-	if src[srcIndex+1:] == dst[dstIndex+1:] && srcIndex != dstIndex {
-		var fi model.ListModel
-		strArr := strings.Split(src, "/")
-		list, _ := aliyun.GetList(h.Config.Token, h.Config.DriveId, "")
-		fi, _ = findUrl(strArr, h.Config.Token, h.Config.DriveId, list)
-
-		strArrParent := strings.Split(dst[:dstIndex], "/")
-		parent, _ := findUrl(strArrParent, h.Config.Token, h.Config.DriveId, list)
-
-		aliyun.BatchFile(h.Config.Token, h.Config.DriveId, fi.FileId, parent.FileId)
-		return http.StatusNoContent, nil
+	if dst == src {
+		return http.StatusForbidden, errDestinationEqualsSource
 	}
 
 	ctx := r.Context()
 
-	if r.Method == "MOVE" {
-		//fmt.Println("move")
-	}
-
 	if r.Method == "COPY" {
 		// Section 7.5.1 says that a COPY only needs to lock the destination,
 		// not both destination and source. Strictly speaking, this is racy,
@@ -573,14 +577,18 @@ func (h *Handler) handleCopyMove(w http.ResponseWriter, r *http.Request) (status
 				return http.StatusBadRequest, errInvalidDepth
 			}
 		}
-		return copyFiles(ctx, h.FileSystem, src, dst, r.Header.Get("Overwrite") != "F", depth, 0)
+		status, err = copyFiles(ctx, h.FileSystem, src, dst, r.Header.Get("Overwrite") != "F", depth, 0)
+		if pe, ok := err.(*PartialError); ok {
+			return h.writePartialError(w, pe)
+		}
+		return status, err
 	}
 
-	//release, status, err := h.confirmLocks(r, src, dst)
-	//if err != nil {
-	//	return status, err
-	//}
-	//defer release()
+	release, status, err := h.confirmLocks(r, src, dst)
+	if err != nil {
+		return status, err
+	}
+	defer release()
 
 	// Section 9.9.2 says that "The MOVE method on a collection must act as if
 	// a "Depth: infinity" header was used on it. A client must not submit a
@@ -590,7 +598,7 @@ func (h *Handler) handleCopyMove(w http.ResponseWriter, r *http.Request) (status
 			return http.StatusBadRequest, errInvalidDepth
 		}
 	}
-	return moveFiles(ctx, h.FileSystem, src, dst, r.Header.Get("Overwrite") == "T")
+	return moveFiles(ctx, h.FileSystem, src, dst, r.Header.Get("Overwrite") != "F")
 }
 
 func (h *Handler) handleLock(w http.ResponseWriter, r *http.Request) (retStatus int, retErr error) {
@@ -614,7 +622,7 @@ func (h *Handler) handleLock(w http.ResponseWriter, r *http.Request) (retStatus
 		return status, err
 	}
 
-	//ctx := r.Context()
+	ctx := r.Context()
 	token, ld, now, created := "", LockDetails{}, time.Now(), false
 	if li == (lockInfo{}) {
 		// An empty lockInfo means to refresh the lock.
@@ -671,18 +679,9 @@ func (h *Handler) handleLock(w http.ResponseWriter, r *http.Request) (retStatus
 			}
 		}()
 
-		// Create the resource if it didn't previously exist.
-		var fi model.ListModel
-		lastIndex := strings.LastIndex(reqPath, "/")
-		if lastIndex == -1 {
-			lastIndex = 0
-		}
-		if len(reqPath) > 0 && !strings.HasSuffix(reqPath, "/") {
-			strArr := strings.Split(reqPath[:lastIndex], "/")
-			list, _ := aliyun.GetList(h.Config.Token, h.Config.DriveId, "")
-			fi, _ = findUrl(strArr, h.Config.Token, h.Config.DriveId, list)
-		}
-		if reflect.DeepEqual(fi, model.ListModel{}) {
+		// The resource is not created if it didn't previously exist, as an
+		// empty upload would replace the file a client is about to PUT.
+		if _, err := h.FileSystem.Stat(ctx, reqPath); err != nil {
 			created = true
 		}
 
@@ -726,76 +725,17 @@ func (h *Handler) handleUnlock(w http.ResponseWriter, r *http.Request) (status i
 }
 
 func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status int, err error) {
-	if r.ContentLength > 0 {
-		available, _ := ioutil.ReadAll(r.Body)
-		if strings.Contains(string(available), "quota-available-bytes") {
-			totle, used := aliyun.GetBoxSize(h.Config.Token)
-			to, _ := strconv.ParseInt(string(totle), 10, 64)
-			us, _ := strconv.ParseInt(string(used), 10, 64)
-			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><D:multistatus xmlns:D="DAV:"><D:response><D:href>/</D:href><D:propstat><D:prop><D:quota-available-bytes>` + strconv.FormatInt(to-us, 10) + `</D:quota-available-bytes><D:quota-used-bytes>` + used + `</D:quota-used-bytes></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>
-			</D:multistatus>`))
-			return 0, nil
-		}
-		//fmt.Println(string(available))
-	}
 	reqPath, status, err := h.stripPrefix(r.URL.Path)
-	var list model.FileListModel
-	var fi model.ListModel
-	fmt.Println(reqPath)
-	var unfindListErr error
-	if len(reqPath) > 0 && strings.HasSuffix(reqPath, "/") {
-		dirName := strings.TrimRight(reqPath, "/")
-		dirName = strings.TrimLeft(dirName, "/")
-		list, err = aliyun.GetList(h.Config.Token, h.Config.DriveId, "")
-		strArr := strings.Split(dirName, "/")
-		fi, _ = findUrl(strArr, h.Config.Token, h.Config.DriveId, list)
-		list, unfindListErr = findList(strArr, h.Config.Token, h.Config.DriveId, fi.FileId)
-
-	} else if len(reqPath) == 0 {
-		list, err = aliyun.GetList(h.Config.Token, h.Config.DriveId, "")
-		if err != nil {
-			//fmt.Println("获取列表失败")
-		}
-		for _, fileInfo := range list.Items {
-			if fileInfo.Type == "folder" {
-				cache.GoCache.SetDefault(reqPath+fileInfo.Name, fileInfo.FileId)
-			}
-		}
-	} else if len(reqPath) > 0 && !strings.HasSuffix(reqPath, "/") {
-		strArr := strings.Split(reqPath, "/")
-		va, ok := cache.GoCache.Get(reqPath)
-		var value string
-		if ok {
-			value = va.(string)
-		} else {
-			value = ""
-		}
-		list, _ = aliyun.GetList(h.Config.Token, h.Config.DriveId, value)
-		for _, fileInfo := range list.Items {
-			if fileInfo.Type == "folder" {
-				cache.GoCache.SetDefault(reqPath+"/"+fileInfo.Name, fileInfo.FileId)
-			}
-		}
-		if len(list.Items) == 0 {
-			fi = aliyun.GetFileDetail(h.Config.Token, h.Config.DriveId, value)
-		} else {
-			//bugfix: use new local scope variable
-			list, _ := aliyun.GetList(h.Config.Token, h.Config.DriveId, "")
-			fi, _ = findUrl(strArr, h.Config.Token, h.Config.DriveId, list)
-		}
-	}
-
 	if err != nil {
 		return status, err
 	}
 	ctx := r.Context()
-	if (err != nil || fi == model.ListModel{}) && reqPath != "" && reqPath != "/" && strings.Index(reqPath, "test.png") == -1 {
-		//新建或修改名称的时候需要判断是否已存在
-		if len(list.Items) == 0 || unfindListErr != nil {
+	fi, err := h.FileSystem.Stat(ctx, reqPath)
+	if err != nil {
+		if os.IsNotExist(err) {
 			return http.StatusNotFound, err
 		}
-
-		//return http.StatusMethodNotAllowed, err
+		return errorStatus(err, http.StatusMethodNotAllowed), err
 	}
 	depth := infiniteDepth
 	if hdr := r.Header.Get("Depth"); hdr != "" {
@@ -804,93 +744,50 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 			return http.StatusBadRequest, errInvalidDepth
 		}
 	}
+	maxNodes := h.MaxPropfindNodes
+	if maxNodes == 0 {
+		maxNodes = DefaultMaxPropfindNodes
+	}
+	if depth == infiniteDepth && maxNodes < 0 {
+		return h.writeFiniteDepthError(w)
+	}
 	pf, status, err := readPropfind(r.Body)
 	if err != nil {
 		return status, err
 	}
 
-	mw := multistatusWriter{w: w}
-
-	//var prefix string = strings.TrimLeft(r.URL.Path, "/")
-	//for _, v := range list.Items {
-	//	var pstats []Propstat
-	//	if pf.Propname != nil {
-	//		pnames, err := propnames(v)
-	//		if err != nil {
-	//			fmt.Println(err)
-	//			continue
-	//		}
-	//		pstat := Propstat{Status: http.StatusOK}
-	//		for _, xmlname := range pnames {
-	//			pstat.Props = append(pstat.Props, Property{XMLName: xmlname})
-	//		}
-	//		pstats = append(pstats, pstat)
-	//	} else if pf.Allprop != nil {
-	//		pstats, err = allprop(ctx, h.FileSystem, h.LockSystem, pf.Prop, v)
-	//	} else {
-	//		pstats, err = props(ctx, h.FileSystem, h.LockSystem, pf.Prop, v)
-	//	}
-	//	if err != nil {
-	//		fmt.Println(err)
-	//		continue
-	//	}
-	//	//href := ""
-	//	//if v.ParentFileId != "root" {
-	//	//	m := aliyun.GetFileDetail(h.Config.Token, h.Config.DriveId, v.ParentFileId)
-	//	//	href += m.Name + "/"
-	//	//}
-	//	//href += v.Name
-	//	href := path.Join(prefix, v.Name)
-	//	if href != "/" && v.Type == "folder" {
-	//		href += "/"
-	//	}
-	//	mw.write(makePropstatResponse(href, pstats))
-	//}
-	//
-	//if len(list.Items) == 0 {
-	//	var pstats []Propstat
-	//	if pf.Propname != nil {
-	//		pnames, err := propnames(fi)
-	//		if err != nil {
-	//			fmt.Println(err)
-	//		}
-	//		pstat := Propstat{Status: http.StatusOK}
-	//		for _, xmlname := range pnames {
-	//			pstat.Props = append(pstat.Props, Property{XMLName: xmlname})
-	//		}
-	//		pstats = append(pstats, pstat)
-	//	} else if pf.Allprop != nil {
-	//		pstats, err = allprop(ctx, h.FileSystem, h.LockSystem, pf.Prop, fi)
-	//	} else {
-	//		pstats, err = props(ctx, h.FileSystem, h.LockSystem, pf.Prop, fi)
-	//	}
-	//	if err != nil {
-	//		fmt.Println(err)
-	//
-	//	}
-	//	href := path.Join(prefix, fi.Name)
-	//	if href != "/" && fi.Type == "folder" {
-	//		href += "/"
-	//	}
-	//	mw.write(makePropstatResponse(href, pstats))
-	//}
-
-	//if err != nil {
-	//	return err
-	//}
-	//
-
-	walkFn := func(parent model.ListModel, info model.FileListModel, err error) error {
-		if reflect.DeepEqual(parent, model.ListModel{}) {
-			parent.Type = "folder"
-			parent.ParentFileId = "root"
+	// A tree too large is refused as a whole rather than answered with a
+	// cut-off multistatus, so it is counted before anything is written.
+	// The listings are cached by the FileSystem if it is drive-backed, so
+	// walking the tree twice does not list it twice.
+	if depth == infiniteDepth {
+		nodes := 0
+		countErr := walkFS(ctx, h.FileSystem, depth, reqPath, fi, func(_ string, _ os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if nodes++; nodes > maxNodes {
+				return errPropfindFiniteDepth
+			}
+			return nil
+		})
+		if countErr == errPropfindFiniteDepth {
+			return h.writeFiniteDepthError(w)
 		}
+		if countErr != nil {
+			return http.StatusInternalServerError, countErr
+		}
+	}
+
+	mw := multistatusWriter{w: w}
+	walkFn := func(reqPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		item := fileModel(info)
 		var pstats []Propstat
 		if pf.Propname != nil {
-			pnames, err := propnames(parent)
+			pnames, err := propnames(item)
 			if err != nil {
 				return err
 			}
@@ -900,30 +797,22 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 			}
 			pstats = append(pstats, pstat)
 		} else if pf.Allprop != nil {
-			pstats, err = allprop(ctx, h.FileSystem, h.LockSystem, pf.Prop, parent)
+			pstats, err = allprop(ctx, h.FileSystem, h.LockSystem, pf.Include, item)
 		} else {
-			pstats, err = props(ctx, h.FileSystem, h.LockSystem, pf.Prop, parent)
+			pstats, err = props(ctx, h.FileSystem, h.LockSystem, pf.Prop, item)
 		}
 		if err != nil {
 			return err
 		}
-		href := path.Join(h.Prefix, parent.Name)
-		if parent.ParentFileId == "root" && parent.FileId == "" {
-			href = "/" + parent.Name
-		} else {
-			href, _ = aliyun.GetFilePath(h.Config.Token, h.Config.DriveId, parent.ParentFileId, parent.FileId, parent.Type)
-			href += parent.Name
-			if parent.Type == "folder" {
-				href += "/"
-			}
-			//list, _ = aliyun.GetList(h.Config.Token, h.Config.DriveId, parent.FileId)
-
+		// The href is made of the request path and the names of the
+		// members walked; makePropstatResponse escapes it.
+		href := path.Join("/", h.Prefix, reqPath)
+		if href != "/" && info.IsDir() {
+			href += "/"
 		}
 		return mw.write(makePropstatResponse(href, pstats))
 	}
-	userAgent := r.Header.Get("User-Agent")
-	cheng := 1
-	walkErr := walkFS(ctx, h.FileSystem, depth, fi, list, walkFn, h.Config.Token, h.Config.DriveId, userAgent, cheng)
+	walkErr := walkFS(ctx, h.FileSystem, depth, reqPath, fi, walkFn)
 	closeErr := mw.close()
 	if walkErr != nil {
 		return http.StatusInternalServerError, walkErr
@@ -934,6 +823,19 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 	return 0, nil
 }
 
+// writeFiniteDepthError refuses a PROPFIND with "Depth: infinity" with the
+// propfind-finite-depth precondition of section 9.1.
+func (h *Handler) writeFiniteDepthError(w http.ResponseWriter) (int, error) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	_, err := fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`)
+	if err != nil {
+		return 0, err
+	}
+	return 0, errPropfindFiniteDepth
+}
+
 func (h *Handler) handleProppatch(w http.ResponseWriter, r *http.Request) (status int, err error) {
 	reqPath, status, err := h.stripPrefix(r.URL.Path)
 	if err != nil {
@@ -973,41 +875,49 @@ func (h *Handler) handleProppatch(w http.ResponseWriter, r *http.Request) (statu
 	return 0, nil
 }
 
-func findUrl(strArr []string, token, driveId string, list model.FileListModel) (model.ListModel, error) {
-	var m model.ListModel
-	for _, v := range list.Items {
-		if v.Name == strArr[0] {
-			m = v
-			if len(strArr) > 1 {
-				list, _ := aliyun.GetList(token, driveId, v.FileId)
-				return findUrl(strArr[1:], token, driveId, list)
-			} else {
-				return m, nil
-			}
+// writePartialError answers with a Multi-Status response listing the members
+// that failed in pe, each with the status code of its error. Section 9.8.8
+// says that the members that succeeded are not listed.
+func (h *Handler) writePartialError(w http.ResponseWriter, pe *PartialError) (int, error) {
+	names := make([]string, 0, len(pe.Errs))
+	for name := range pe.Errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	mw := multistatusWriter{w: w}
+	var writeErr error
+	for _, name := range names {
+		status := errorStatus(pe.Errs[name], http.StatusInternalServerError)
+		if os.IsNotExist(pe.Errs[name]) {
+			status = http.StatusNotFound
+		}
+		writeErr = mw.write(&response{
+			Href:   []string{escapePath(path.Join("/", h.Prefix, name))},
+			Status: fmt.Sprintf("HTTP/1.1 %d %s", status, StatusText(status)),
+		})
+		if writeErr != nil {
+			break
 		}
 	}
-	return m, nil
+	closeErr := mw.close()
+	if writeErr != nil {
+		return http.StatusInternalServerError, writeErr
+	}
+	if closeErr != nil {
+		return http.StatusInternalServerError, closeErr
+	}
+	return 0, pe
 }
 
-func findList(strArr []string, token, driveId string, parentId string) (model.FileListModel, error) {
-	var list model.FileListModel
-	var err error
-	err = errors.New("未找到数据")
-	list, _ = aliyun.GetList(token, driveId, parentId)
-	num := 0
-	for _, a := range strArr {
-		for _, v := range list.Items {
-			if v.Name == a {
-				list, _ = aliyun.GetList(token, driveId, v.FileId)
-				num += 1
-				break
-			}
-		}
-	}
-	if num == len(strArr) {
-		err = nil
+// errorStatus returns the HTTP status code for err, an error returned by a
+// FileSystem. Errors with an HTTPStatus method, such as failures reported by
+// a remote drive, choose their own status code; other errors get fallback.
+func errorStatus(err error, fallback int) int {
+	var se interface{ HTTPStatus() int }
+	if errors.As(err, &se) {
+		return se.HTTPStatus()
 	}
-	return list, err
+	return fallback
 }
 
 func makePropstatResponse(href string, pstats []Propstat) *response {
@@ -1083,6 +993,8 @@ func StatusText(code int) string {
 
 var (
 	errDestinationEqualsSource = errors.New("webdav: destination equals source")
+	errDestinationInsideSource = errors.New("webdav: destination inside source")
+	errSourceInsideDestination = errors.New("webdav: source inside destination")
 	errDirectoryNotEmpty       = errors.New("webdav: directory not empty")
 	errInvalidDepth            = errors.New("webdav: invalid depth")
 	errInvalidDestination      = errors.New("webdav: invalid destination")
@@ -1097,6 +1009,7 @@ var (
 	errNoLockSystem            = errors.New("webdav: no lock system")
 	errNotADirectory           = errors.New("webdav: not a directory")
 	errPrefixMismatch          = errors.New("webdav: prefix mismatch")
+	errPropfindFiniteDepth     = errors.New("webdav: too many resources for Depth: infinity")
 	errRecursionTooDeep        = errors.New("webdav: recursion too deep")
 	errUnsupportedLockInfo     = errors.New("webdav: unsupported lock info")
 	errUnsupportedMethod       = errors.New("webdav: unsupported method")
@@ -0,0 +1,148 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webdav
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// serve sends a request with the given headers to h and returns the
+// response.
+func serve(h http.Handler, method, target string, header map[string]string, body string) *httptest.ResponseRecorder {
+	var rb io.Reader
+	if body != "" {
+		rb = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, target, rb)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// propfindSizes sends a PROPFIND for the getcontentlength property and
+// returns the lengths reported by href, with "" for collections.
+func propfindSizes(t *testing.T, h http.Handler, target, depth string) map[string]string {
+	t.Helper()
+	const body = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:getcontentlength/></D:prop></D:propfind>`
+	w := serve(h, "PROPFIND", target, map[string]string{"Depth": depth}, body)
+	if w.Code != StatusMulti {
+		t.Fatalf("PROPFIND %s: status %d, want %d", target, w.Code, StatusMulti)
+	}
+	var ms struct {
+		Responses []struct {
+			Href     string `xml:"href"`
+			Propstat []struct {
+				Length string `xml:"prop>getcontentlength"`
+				Status string `xml:"status"`
+			} `xml:"propstat"`
+		} `xml:"response"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &ms); err != nil {
+		t.Fatalf("PROPFIND %s: %v\n%s", target, err, w.Body)
+	}
+	sizes := make(map[string]string)
+	for _, resp := range ms.Responses {
+		sizes[resp.Href] = ""
+		for _, ps := range resp.Propstat {
+			if strings.Contains(ps.Status, " 200 ") {
+				sizes[resp.Href] = ps.Length
+			}
+		}
+	}
+	return sizes
+}
+
+func TestRoundTrip(t *testing.T) {
+	h := &Handler{
+		Prefix:     "/dav",
+		FileSystem: NewMemFS(),
+		LockSystem: NewMemLS(),
+	}
+
+	if w := serve(h, "MKCOL", "/dav/d", nil, ""); w.Code != http.StatusCreated {
+		t.Fatalf("MKCOL: status %d", w.Code)
+	}
+	if w := serve(h, "PUT", "/dav/d/a.txt", nil, "hello"); w.Code != http.StatusCreated {
+		t.Fatalf("PUT: status %d", w.Code)
+	}
+	if w := serve(h, "PUT", "/dav/d/b%20c.txt", nil, "hello, world"); w.Code != http.StatusCreated {
+		t.Fatalf("PUT: status %d", w.Code)
+	}
+	if w := serve(h, "PUT", "/dav/d/a.txt", nil, "hello!"); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT over a file: status %d", w.Code)
+	}
+
+	w := serve(h, "GET", "/dav/d/a.txt", nil, "")
+	if w.Code != http.StatusOK || w.Body.String() != "hello!" {
+		t.Fatalf("GET: status %d, body %q", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Length"); got != "6" {
+		t.Errorf("GET: Content-Length %q", got)
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("GET: Content-Type %q", got)
+	}
+	if w.Header().Get("ETag") == "" {
+		t.Error("GET: no ETag")
+	}
+
+	got := propfindSizes(t, h, "/dav/d", "1")
+	want := map[string]string{
+		"/dav/d/":          "",
+		"/dav/d/a.txt":     "6",
+		"/dav/d/b%20c.txt": "12",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PROPFIND Depth 1 = %v, want %v", got, want)
+	}
+	got = propfindSizes(t, h, "/dav/", "infinity")
+	var hrefs []string
+	for href := range got {
+		hrefs = append(hrefs, href)
+	}
+	sort.Strings(hrefs)
+	if want := []string{"/dav/", "/dav/d/", "/dav/d/a.txt", "/dav/d/b%20c.txt"}; !reflect.DeepEqual(hrefs, want) {
+		t.Errorf("PROPFIND Depth infinity = %q, want %q", hrefs, want)
+	}
+
+	if w := serve(h, "DELETE", "/dav/d/a.txt", nil, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: status %d", w.Code)
+	}
+	if w := serve(h, "GET", "/dav/d/a.txt", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := serve(h, "DELETE", "/dav/d/a.txt", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE twice: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	got = propfindSizes(t, h, "/dav/d/", "1")
+	want = map[string]string{
+		"/dav/d/":          "",
+		"/dav/d/b%20c.txt": "12",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PROPFIND after DELETE = %v, want %v", got, want)
+	}
+
+	if w := serve(h, "DELETE", "/dav/d", nil, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE collection: status %d", w.Code)
+	}
+	if w := serve(h, "PROPFIND", "/dav/d/b%20c.txt", map[string]string{"Depth": "0"}, ""); w.Code != http.StatusNotFound {
+		t.Errorf("PROPFIND after DELETE collection: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := serve(h, "GET", "/elsewhere/d", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET outside the prefix: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}